@@ -0,0 +1,112 @@
+package argspolicy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Policy describes which arguments a job payload may pass to the command. Anything that
+// isn't explicitly allowed is rejected. Use New or Load to create one.
+type Policy struct {
+	maxArgs     int
+	flags       map[string]bool
+	positionals []*regexp.Regexp
+}
+
+// policyFile is the YAML representation of a Policy.
+type policyFile struct {
+	// Flags lists the allowed flags, e.g. "-i" or "--verbose".
+	Flags []string `yaml:"flags"`
+	// Positionals lists regular expressions, at least one of which every non-flag argument
+	// must match in full.
+	Positionals []string `yaml:"positionals"`
+	MaxArgs     int      `yaml:"max_args"`
+}
+
+// New creates a policy allowing the given flags, non-flag arguments matching any of the
+// positional patterns in full, and at most maxArgs arguments (zero means no maximum).
+// A flag passed as "--name=value" is matched on "--name", and its value has to match one
+// of the positional patterns, as does a flag value passed as a separate argument.
+func New(flags, positionals []string, maxArgs int) (*Policy, error) {
+	if maxArgs < 0 {
+		return nil, fmt.Errorf("max_args must not be negative")
+	}
+	p := &Policy{maxArgs: maxArgs, flags: map[string]bool{}}
+	for _, flag := range flags {
+		if !strings.HasPrefix(flag, "-") {
+			return nil, fmt.Errorf("flag %q must start with '-'", flag)
+		}
+		p.flags[flag] = true
+	}
+	for _, pattern := range positionals {
+		// anchor the pattern so that it has to match the entire argument
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("bad positional pattern %q: %s", pattern, err.Error())
+		}
+		p.positionals = append(p.positionals, re)
+	}
+	return p, nil
+}
+
+// Load reads the YAML policy file at path.
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file policyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", path, err.Error())
+	}
+	p, err := New(file.Flags, file.Positionals, file.MaxArgs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %s", path, err.Error())
+	}
+	return p, nil
+}
+
+// Check returns an error describing the first argument that the policy doesn't allow.
+func (p *Policy) Check(args []string) error {
+	if p.flags == nil {
+		return fmt.Errorf("policy wasn't created with New or Load")
+	}
+	if p.maxArgs > 0 && len(args) > p.maxArgs {
+		return fmt.Errorf("%d arguments given, at most %d allowed", len(args), p.maxArgs)
+	}
+	flagsDone := false
+	for _, arg := range args {
+		if !flagsDone && arg == "--" {
+			// everything after "--" is a positional
+			flagsDone = true
+			continue
+		}
+		if !flagsDone && strings.HasPrefix(arg, "-") && arg != "-" {
+			parts := strings.SplitN(arg, "=", 2)
+			if !p.flags[parts[0]] {
+				return fmt.Errorf("flag %q is not allowed", parts[0])
+			}
+			if len(parts) == 2 && !p.matchesPositional(parts[1]) {
+				return fmt.Errorf("value %q of flag %q is not allowed", parts[1], parts[0])
+			}
+			continue
+		}
+		if !p.matchesPositional(arg) {
+			return fmt.Errorf("argument %q is not allowed", arg)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) matchesPositional(arg string) bool {
+	for _, re := range p.positionals {
+		if re.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
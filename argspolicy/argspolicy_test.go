@@ -0,0 +1,78 @@
+package argspolicy
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy(t *testing.T) *Policy {
+	p, err := New([]string{"-i", "--format"}, []string{`[a-z]+\.txt`, "json|csv"}, 5)
+	assert.NoError(t, err)
+	return p
+}
+
+func TestCheckAllowed(t *testing.T) {
+	p := testPolicy(t)
+	assert.NoError(t, p.Check([]string{}))
+	assert.NoError(t, p.Check([]string{"-i", "--format", "csv", "input.txt"}))
+	assert.NoError(t, p.Check([]string{"--format=json", "input.txt"}))
+	assert.NoError(t, p.Check([]string{"-i", "--", "input.txt"}))
+}
+
+func TestCheckRejected(t *testing.T) {
+	p := testPolicy(t)
+	assert.EqualError(t, p.Check([]string{"--output", "/etc/passwd"}), `flag "--output" is not allowed`)
+	assert.EqualError(t, p.Check([]string{"--output=/etc/passwd"}), `flag "--output" is not allowed`)
+	// values of allowed flags are checked against the positional patterns, however they're passed
+	assert.EqualError(t, p.Check([]string{"--format=/etc/passwd"}), `value "/etc/passwd" of flag "--format" is not allowed`)
+	assert.EqualError(t, p.Check([]string{"--format", "/etc/passwd"}), `argument "/etc/passwd" is not allowed`)
+	// patterns have to match the whole argument
+	assert.EqualError(t, p.Check([]string{"../input.txt"}), `argument "../input.txt" is not allowed`)
+	// flags after "--" are positionals
+	assert.EqualError(t, p.Check([]string{"--", "-i"}), `argument "-i" is not allowed`)
+	assert.EqualError(t, p.Check([]string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt", "f.txt"}),
+		"6 arguments given, at most 5 allowed")
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := New(nil, []string{"("}, 0)
+	assert.Error(t, err)
+	_, err = New([]string{"format"}, nil, 0)
+	assert.EqualError(t, err, `flag "format" must start with '-'`)
+	_, err = New(nil, nil, -1)
+	assert.Error(t, err)
+}
+
+func TestCheckUncompiledPolicy(t *testing.T) {
+	assert.EqualError(t, (&Policy{}).Check([]string{}), "policy wasn't created with New or Load")
+}
+
+func TestLoad(t *testing.T) {
+	file, err := ioutil.TempFile("", "policy")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("flags: [\"-v\"]\npositionals: ['\\d+']\nmax_args: 2\n")
+	assert.NoError(t, err)
+	file.Close()
+
+	p, err := Load(file.Name())
+	assert.NoError(t, err)
+	assert.NoError(t, p.Check([]string{"-v", "42"}))
+	assert.EqualError(t, p.Check([]string{"-v", "4", "2"}), "3 arguments given, at most 2 allowed")
+	assert.Error(t, p.Check([]string{"-v", "abc"}))
+}
+
+func TestLoadInvalid(t *testing.T) {
+	file, err := ioutil.TempFile("", "policy")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("positionals: ['(']\n")
+	assert.NoError(t, err)
+	file.Close()
+
+	_, err = Load(file.Name())
+	assert.Error(t, err)
+}
@@ -0,0 +1,292 @@
+// Package cgroup puts jobs into their own cgroup v2 sub-groups, so their memory and CPU use
+// can be limited and measured as a whole.
+//
+// gearcmd needs a delegated cgroup to create the sub-groups in, e.g. one from systemd's
+// Delegate=yes. cgroup v2 doesn't allow controllers to be enabled for the children of a
+// cgroup that has processes of its own, so with Auto gearcmd moves the processes of its own
+// cgroup into a leaf sub-group first.
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auto uses the cgroup gearcmd runs in.
+const Auto = "auto"
+
+// leafName is the sub-group the processes of gearcmd's own cgroup are moved to with Auto.
+const leafName = "gearcmd"
+
+// cpuPeriod is the period of cpu.max, in microseconds.
+const cpuPeriod = 100000
+
+// Limits are the limits of a job's group. Zero means no limit.
+type Limits struct {
+	// MemoryMax is in bytes.
+	MemoryMax int64
+	// CPUMax is in CPUs, e.g. 1.5 lets the job use one and a half CPUs' worth of time.
+	CPUMax float64
+}
+
+func (l Limits) controllers() []string {
+	var controllers []string
+	if l.MemoryMax > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if l.CPUMax > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	return controllers
+}
+
+// Manager creates the groups of jobs.
+type Manager struct {
+	root   string
+	limits Limits
+}
+
+// Setup prepares root, a cgroup v2 directory, or Auto, for groups with the given limits.
+func Setup(root string, limits Limits) (*Manager, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("cgroups are only supported on Linux")
+	}
+	if root == Auto {
+		var err error
+		if root, err = ownCgroup(); err != nil {
+			return nil, err
+		}
+		if err := moveProcesses(root, filepath.Join(root, leafName)); err != nil {
+			return nil, err
+		}
+	}
+	return setup(root, limits)
+}
+
+func setup(root string, limits Limits) (*Manager, error) {
+	available, err := ioutil.ReadFile(filepath.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't a cgroup v2 directory: %s", root, err.Error())
+	}
+	var enable []string
+	for _, controller := range limits.controllers() {
+		if !containsField(string(available), controller) {
+			return nil, fmt.Errorf("the %s controller isn't available in %s", controller, root)
+		}
+		enable = append(enable, "+"+controller)
+	}
+	// memory.peak and memory.events are only there with the memory controller
+	if limits.MemoryMax <= 0 && containsField(string(available), "memory") {
+		enable = append(enable, "+memory")
+	}
+	if len(enable) > 0 {
+		if err := writeFile(root, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+			return nil, err
+		}
+	}
+	return &Manager{root: root, limits: limits}, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 the process is in.
+func ownCgroup() (string, error) {
+	mountPoint, mountRoot, err := cgroup2Mount()
+	if err != nil {
+		return "", err
+	}
+	contents, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		path := strings.TrimPrefix(strings.TrimPrefix(line, "0::"), mountRoot)
+		if path == "" || path == "/" {
+			return "", fmt.Errorf("gearcmd is in the root cgroup, which isn't delegated")
+		}
+		return filepath.Join(mountPoint, path), nil
+	}
+	return "", fmt.Errorf("gearcmd isn't in a cgroup v2")
+}
+
+// cgroup2Mount returns where cgroup v2 is mounted, and which cgroup is mounted there.
+func cgroup2Mount() (string, string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 42 32 0:38 / /sys/fs/cgroup rw,relatime shared:9 - cgroup2 cgroup2 rw
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) == 2 && len(fields) >= 5 && strings.HasPrefix(parts[1], "cgroup2 ") {
+			return fields[4], fields[3], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	return "", "", fmt.Errorf("cgroup v2 isn't mounted")
+}
+
+// moveProcesses moves all the processes in the cgroup from to the cgroup to, which is
+// created if it doesn't exist.
+func moveProcesses(from, to string) error {
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(from, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(contents)) {
+		if err := writeFile(to, "cgroup.procs", pid); err != nil {
+			// processes that exited in the meantime can't be moved
+			if _, statErr := os.Stat(filepath.Join("/proc", pid)); statErr == nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Limits returns the limits of the groups the Manager creates.
+func (m *Manager) Limits() Limits {
+	return m.limits
+}
+
+// Group is the cgroup of a job.
+type Group struct {
+	path string
+}
+
+// NewGroup creates the group name with the Manager's limits.
+func (m *Manager) NewGroup(name string) (*Group, error) {
+	path := filepath.Join(m.root, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create cgroup: %s", err.Error())
+	}
+	group := &Group{path: path}
+	if m.limits.MemoryMax > 0 {
+		if err := writeFile(path, "memory.max", strconv.FormatInt(m.limits.MemoryMax, 10)); err != nil {
+			group.Remove()
+			return nil, err
+		}
+		// without swap the limit would only slow the job down instead of stopping it
+		writeFile(path, "memory.swap.max", "0")
+	}
+	if m.limits.CPUMax > 0 {
+		if err := writeFile(path, "cpu.max", cpuMax(m.limits.CPUMax)); err != nil {
+			group.Remove()
+			return nil, err
+		}
+	}
+	return group, nil
+}
+
+// cpuMax returns the cpu.max that lets a group use cpus CPUs.
+func cpuMax(cpus float64) string {
+	return fmt.Sprintf("%d %d", int64(cpus*cpuPeriod), cpuPeriod)
+}
+
+// Path is the group's directory. A process joins the group by writing its pid to the
+// cgroup.procs file in it.
+func (g *Group) Path() string {
+	return g.path
+}
+
+// Stats is what the group's processes used. The fields are zero if the kernel doesn't
+// report them.
+type Stats struct {
+	// OOMKills is the number of processes killed for exceeding the memory limit.
+	OOMKills int64
+	// MemoryPeak is the most memory the group used at once, in bytes.
+	MemoryPeak int64
+	// CPUUsage, CPUUser and CPUSystem are the CPU time used in total, in user mode and in
+	// kernel mode.
+	CPUUsage, CPUUser, CPUSystem time.Duration
+}
+
+// Stats reads the group's stats.
+func (g *Group) Stats() (Stats, error) {
+	var stats Stats
+	cpu, err := readKeyedFile(g.path, "cpu.stat")
+	if err != nil {
+		return stats, err
+	}
+	stats.CPUUsage = time.Duration(cpu["usage_usec"]) * time.Microsecond
+	stats.CPUUser = time.Duration(cpu["user_usec"]) * time.Microsecond
+	stats.CPUSystem = time.Duration(cpu["system_usec"]) * time.Microsecond
+	if events, err := readKeyedFile(g.path, "memory.events"); err == nil {
+		stats.OOMKills = events["oom_kill"]
+	}
+	if peak, err := ioutil.ReadFile(filepath.Join(g.path, "memory.peak")); err == nil {
+		stats.MemoryPeak, _ = strconv.ParseInt(strings.TrimSpace(string(peak)), 10, 64)
+	}
+	return stats, nil
+}
+
+// Remove kills whatever is left in the group and removes it.
+func (g *Group) Remove() error {
+	// cgroup.kill is only there from Linux 5.14
+	writeFile(g.path, "cgroup.kill", "1")
+	var err error
+	for i := 0; i < 50; i++ {
+		// the group can only be removed once its processes are gone
+		if err = os.Remove(g.path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("unable to remove cgroup %s: %s", g.path, err.Error())
+}
+
+// readKeyedFile reads a file of "<key> <value>" lines.
+func readKeyedFile(dir, name string) (map[string]int64, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]int64{}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
+
+func writeFile(dir, name, value string) error {
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(value); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write %q to %s: %s", value, filepath.Join(dir, name), err.Error())
+	}
+	return file.Close()
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
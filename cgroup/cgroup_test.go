@@ -0,0 +1,121 @@
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCgroup creates a directory that looks like a cgroup v2 directory with the given
+// controllers available.
+func fakeCgroup(t *testing.T, controllers string) string {
+	dir, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	for name, contents := range map[string]string{
+		"cgroup.controllers":     controllers,
+		"cgroup.subtree_control": "",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	return dir
+}
+
+func TestSetupEnablesControllers(t *testing.T) {
+	root := fakeCgroup(t, "cpuset cpu io memory pids\n")
+	defer os.RemoveAll(root)
+	manager, err := setup(root, Limits{CPUMax: 1.5})
+	assert.NoError(t, err)
+	assert.Equal(t, Limits{CPUMax: 1.5}, manager.Limits())
+	subtreeControl, err := ioutil.ReadFile(filepath.Join(root, "cgroup.subtree_control"))
+	assert.NoError(t, err)
+	assert.Equal(t, "+cpu +memory", string(subtreeControl))
+}
+
+func TestSetupMissingController(t *testing.T) {
+	root := fakeCgroup(t, "cpu io pids\n")
+	defer os.RemoveAll(root)
+	_, err := setup(root, Limits{MemoryMax: 1024})
+	assert.EqualError(t, err, fmt.Sprintf("the memory controller isn't available in %s", root))
+
+	_, err = setup(filepath.Join(root, "missing"), Limits{})
+	assert.Error(t, err)
+}
+
+func TestNewGroupFailure(t *testing.T) {
+	root := fakeCgroup(t, "cpu memory\n")
+	defer os.RemoveAll(root)
+	manager, err := setup(root, Limits{MemoryMax: 1024})
+	assert.NoError(t, err)
+	// a plain directory has no memory.max to write to, and the group is removed again
+	_, err = manager.NewGroup("job")
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(root, "job"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCPUMax(t *testing.T) {
+	assert.Equal(t, "150000 100000", cpuMax(1.5))
+	assert.Equal(t, "50000 100000", cpuMax(0.5))
+}
+
+func TestGroupStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, contents := range map[string]string{
+		"cpu.stat":      "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\nnr_periods 0\n",
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"memory.peak":   "1048576\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+	stats, err := (&Group{path: dir}).Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{
+		OOMKills:   1,
+		MemoryPeak: 1048576,
+		CPUUsage:   2500 * time.Microsecond,
+		CPUUser:    2000 * time.Microsecond,
+		CPUSystem:  500 * time.Microsecond,
+	}, stats)
+
+	// without the memory controller there's only cpu.stat
+	assert.NoError(t, os.Remove(filepath.Join(dir, "memory.events")))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "memory.peak")))
+	stats, err = (&Group{path: dir}).Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.MemoryPeak)
+	assert.Equal(t, 2500*time.Microsecond, stats.CPUUsage)
+}
+
+func TestRealGroup(t *testing.T) {
+	mountPoint, _, err := cgroup2Mount()
+	if err != nil {
+		t.Skipf("no cgroup v2: %s", err.Error())
+	}
+	root := filepath.Join(mountPoint, fmt.Sprintf("gearcmd-test-%d", os.Getpid()))
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Skipf("can't create a cgroup: %s", err.Error())
+	}
+	defer os.Remove(root)
+	manager, err := setup(root, Limits{})
+	assert.NoError(t, err)
+	group, err := manager.NewGroup("job")
+	assert.NoError(t, err)
+
+	cmd := exec.Command("/bin/sh", "-c", `echo $$ > cgroup.procs; i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done`)
+	cmd.Dir = group.Path()
+	assert.NoError(t, cmd.Run())
+	stats, err := group.Stats()
+	assert.NoError(t, err)
+	assert.True(t, stats.CPUUsage > 0)
+	assert.NoError(t, group.Remove())
+	_, err = os.Stat(group.Path())
+	assert.True(t, os.IsNotExist(err))
+}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Clever/discovery-go"
+	"github.com/Clever/gearcmd/argspolicy"
 	"github.com/Clever/gearcmd/baseworker"
+	"github.com/Clever/gearcmd/cgroup"
 	"github.com/Clever/gearcmd/gearcmd"
+	"github.com/Clever/gearcmd/procinit"
+	"github.com/Clever/gearcmd/watchdog"
+	"github.com/Clever/gearcmd/workdata"
 	"gopkg.in/Clever/kayvee-go.v6/logger"
 )
 
@@ -19,7 +25,24 @@ var (
 	lg = logger.New("gearcmd")
 )
 
+// stringsFlag is a flag that can be given more than once.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 func main() {
+	// when gearcmd is started to set up a job's command, this runs the command instead
+	procinit.Init()
+	// and when it's started as a watchdog, this runs the watchdog
+	watchdog.Init()
+
 	functionName := flag.String("name", "", "Name of the Gearman function")
 	functionCmd := flag.String("cmd", "", "The command to run")
 	gearmanHost := flag.String("host", "", "The Gearman host. If not specified the SERVICE_GEARMAND_TCP_HOST environment variable will be used")
@@ -27,12 +50,61 @@ func main() {
 	parseArgs := flag.Bool("parseargs", true, "If false send the job payload directly to the cmd as its first argument without parsing it")
 	printVersion := flag.Bool("version", false, "Print the version and exit")
 	cmdTimeout := flag.Duration("cmdtimeout", 0, "Maximum time for the command to run before it will be killed, e.g. 2h, 30m, 2h30m")
+	jobIDSourceFlag := flag.String("job-id-source", "handle-suffix", "Where the JOB_ID is taken from: handle-suffix, unique-id, uuid or regex:<expression with a capture group matched against the handle>")
+	workerID := flag.String("worker-id", "", "ID of this worker, passed to the cmd as WORKER_ID. Defaults to <hostname>-<pid>")
+	jobDeadline := flag.Duration("job-deadline", 0, "Maximum time for all tries of a job, including the waits between them, e.g. 2h, 30m, 2h30m")
 	retryCount := flag.Int("retry", 0, "Number of times to retry the job if it fails")
+	retryBackoff := flag.Duration("retry-backoff", 0, "How long to wait before the first retry of a failed job. 0 retries right away")
+	retryBackoffMultiplier := flag.Float64("retry-backoff-multiplier", 2, "What the wait is multiplied by after every retry")
+	retryBackoffMax := flag.Duration("retry-backoff-max", 5*time.Minute, "Longest wait between retries. 0 means no maximum")
+	retryBackoffJitter := flag.Float64("retry-backoff-jitter", 0, "Up to this fraction, from 0 to 1, is randomly taken off every wait between retries")
 	warningLength := flag.Int("warningLength", 5, "Number of warning lines to store and send back to the gearmn job")
+	liveWarnings := flag.Bool("live-warnings", false, "If true, also send stderr lines as warnings while the cmd runs, not just the last lines when it ends")
+	liveWarningInterval := flag.Duration("live-warning-interval", 5*time.Second, "Minimum time between two live warnings, stderr lines are batched in between")
+	stopSignalName := flag.String("stop-signal", "SIGTERM", "Signal sent to the cmd to stop it on timeout or, with -pass-sigterm, when gearcmd is stopped, e.g. SIGINT or SIGQUIT")
+	forwardSignalNames := flag.String("forward-signals", "", "Comma separated signals gearcmd forwards to the running cmd's process group, e.g. HUP,USR1,USR2")
 	passSigterm := flag.Bool("pass-sigterm", true, "Whether or not to pass SIGTERM through to the worker process")
 	sigtermGracePeriod := flag.Duration("sigterm-grace-period", 20*time.Second, "How long to wait after SIGTERM to send SIGKILL. 20s default.")
 	errorBackoffCount := flag.Int("error-backoff-count", 5, "How many errors in a row before we wait before erroring jobs")
 	errorBackoffRate := flag.Duration("error-backoff-rate", 5*time.Second, "How much time to sleep if last 'error-backoff-count' jobs have failed, e.g. 500ms, 1s")
+	jobLogs := flag.String("job-logs", gearcmd.JobLogsPassthrough, "Where to log the cmd's stdout and stderr: passthrough (gearcmd's stdout and stderr), files (one file per job in -job-log-dir) or prefix (gearcmd's stdout and stderr, with each line prefixed by the job ID and stream)")
+	jobLogDir := flag.String("job-log-dir", "", "Directory for job log files with -job-logs=files")
+	jobLogMaxFiles := flag.Int("job-log-max-files", 100, "Maximum number of job log files to keep, 0 means no maximum")
+	jobLogMaxAge := flag.Duration("job-log-max-age", 7*24*time.Hour, "How long to keep job log files, e.g. 24h, 0 means forever")
+	outputFraming := flag.String("output-framing", gearcmd.FramingRaw, "How to split the cmd's stdout into WORK_DATA packets: raw, line or batch")
+	outputEncoding := flag.String("output-encoding", workdata.EncodingIdentity, "Encoding of the WORK_DATA sent for the cmd's stdout: identity or gzip")
+	outputBatchSize := flag.Int("output-batch-size", 64*1024, "Size in bytes of a WORK_DATA packet with -output-framing=batch, and the longest line sent as one packet with -output-framing=line")
+	outputFlushInterval := flag.Duration("output-flush-interval", time.Second, "With -output-framing=batch, the longest stdout is held before it's sent, e.g. 500ms, 5s")
+	maxStdoutBytes := flag.Int64("max-stdout-bytes", 0, "Maximum number of bytes of the cmd's stdout to pass on, 0 means no maximum")
+	maxStderrBytes := flag.Int64("max-stderr-bytes", 0, "Maximum number of bytes of the cmd's stderr to pass on, 0 means no maximum")
+	outputLimitPolicy := flag.String("output-limit-policy", gearcmd.LimitPolicyTruncate, "What to do when the cmd goes over -max-stdout-bytes or -max-stderr-bytes: truncate (drop the rest and warn) or fail (kill the cmd)")
+	resultFile := flag.Bool("result-file", false, "If true, send the contents of $RESULT_FILE as the job result instead of streaming the cmd's stdout")
+	workDirRoot := flag.String("workdir-root", "", "Directory WORK_DIRs are created in. Defaults to $MESOS_SANDBOX, or the system temp dir if that isn't set")
+	minFreeSpace := flag.Int64("min-free-space", 0, "Minimum free bytes on the workdir-root volume to take a job. 0 means no minimum")
+	freeSpaceCheckInterval := flag.Duration("free-space-check-interval", 30*time.Second, "How often free space is checked while waiting for -min-free-space")
+	keepWorkDirOnFailure := flag.Bool("keep-workdir-on-failure", false, "Keep the WORK_DIR of failed tries in -failed-workdir-dir for debugging")
+	failedWorkDirRoot := flag.String("failed-workdir-dir", "", "Directory failed tries' WORK_DIRs are moved to with -keep-workdir-on-failure")
+	failedWorkDirMaxCount := flag.Int("failed-workdir-max-count", 10, "Maximum number of failed WORK_DIRs to keep, the oldest are removed first. 0 means no maximum")
+	failedWorkDirMaxBytes := flag.Int64("failed-workdir-max-bytes", 1024*1024*1024, "Maximum total size in bytes of the failed WORK_DIRs kept, the oldest are removed first. 0 means no maximum")
+	resultFileMaxSize := flag.Int64("result-file-max-size", 16*1024*1024, "Maximum size in bytes of the result file. Jobs with a larger result file fail. 0 means no maximum")
+	progress := flag.Bool("progress", false, "If true, forward 'numerator/denominator' lines the cmd writes to $PROGRESS_FD as WORK_STATUS updates")
+	progressInterval := flag.Duration("progress-interval", time.Second, "Minimum time between two WORK_STATUS updates, e.g. 500ms, 5s")
+	envMode := flag.String("env-mode", "inherit", "Which of gearcmd's env vars the cmd gets: inherit (all of them), allowlist (the ones in -env-allow) or clean (none)")
+	envAllow := flag.String("env-allow", "", "Comma separated env var names, or prefixes ending in *, the cmd gets with -env-mode=allowlist, e.g. PATH,HOME,AWS_*")
+	var env stringsFlag
+	flag.Var(&env, "env", "KEY=VALUE env var to give the cmd, can be given more than once")
+	secretsDir := flag.String("secrets-dir", "", "Directory of secret files to give the cmd as env vars named like the files")
+	var secretFlags stringsFlag
+	flag.Var(&secretFlags, "secret", "<file>=<var> secret file to give the cmd as an env var, can be given more than once")
+	runAs := flag.String("run-as", "", "user[:group] to run the cmd as, by name or ID. Defaults to gearcmd's own user")
+	useWatchdog := flag.Bool("watchdog", false, "Run a watchdog process that kills the running job's process group if gearcmd dies, e.g. because it's SIGKILLed or OOM killed")
+	sandbox := flag.Bool("sandbox", false, "Run the cmd in its own mount, PID, network and IPC namespaces, with no network and WORK_DIR as the only writable directory. Linux only, needs CAP_SYS_ADMIN")
+	rlimitsFlag := flag.String("rlimits", "", "Comma separated <name>=<value> resource limits for the cmd, where the name is as (bytes), core (bytes), cpu (seconds), fsize (bytes), nofile or nproc, e.g. as=1073741824,cpu=60")
+	cgroupRoot := flag.String("cgroup", "", "Delegated cgroup v2 directory to run every job in its own sub-group of, or auto for gearcmd's own cgroup. Disabled if not set")
+	cgroupMemoryMax := flag.Int64("cgroup-memory-max", 0, "Maximum memory in bytes of a job's cgroup. 0 means no maximum")
+	cgroupCPUMax := flag.Float64("cgroup-cpu-max", 0, "Maximum CPUs a job's cgroup can use, e.g. 1.5. 0 means no maximum")
+	exitCodePolicyFlag := flag.String("exit-code-policy", "", "Comma separated <code>=<classification> or <low>-<high>=<classification> rules, where the classification is retry, fail or success, e.g. 75=retry,64-70=fail,3=success")
+	argsPolicyPath := flag.String("args-policy", "", "Path to a YAML file listing the flags, positional argument patterns and maximum number of arguments a job may pass to the cmd")
 	flag.Parse()
 
 	if *printVersion {
@@ -61,17 +133,197 @@ func main() {
 		exitWithError("cmd not defined")
 	}
 
+	if err := gearcmd.ValidateJobLogs(*jobLogs); err != nil {
+		exitWithError(err.Error())
+	}
+	if *jobLogs == gearcmd.JobLogsFiles {
+		if *jobLogDir == "" {
+			exitWithError("job-log-dir must be set with -job-logs=files")
+		}
+		if err := os.MkdirAll(*jobLogDir, 0755); err != nil {
+			exitWithError(fmt.Sprintf("unable to create job log dir: %s", err.Error()))
+		}
+	}
+	if *workDirRoot != "" {
+		if err := os.MkdirAll(*workDirRoot, 0755); err != nil {
+			exitWithError(fmt.Sprintf("unable to create workdir root: %s", err.Error()))
+		}
+	}
+	if *keepWorkDirOnFailure {
+		if *failedWorkDirRoot == "" {
+			exitWithError("failed-workdir-dir must be set with -keep-workdir-on-failure")
+		}
+		if err := os.MkdirAll(*failedWorkDirRoot, 0755); err != nil {
+			exitWithError(fmt.Sprintf("unable to create failed workdir dir: %s", err.Error()))
+		}
+	}
+	if err := gearcmd.ValidateFraming(*outputFraming); err != nil {
+		exitWithError(err.Error())
+	}
+
+	if err := workdata.ValidateEncoding(*outputEncoding); err != nil {
+		exitWithError(err.Error())
+	}
+	if *outputEncoding == workdata.EncodingGzip && *outputFraming == gearcmd.FramingLine {
+		exitWithError("line output framing can't be used with gzip output encoding")
+	}
+	if err := gearcmd.ValidateLimitPolicy(*outputLimitPolicy); err != nil {
+		exitWithError(err.Error())
+	}
+
+	if err := gearcmd.ValidateRetryBackoff(*retryBackoffMultiplier, *retryBackoffJitter); err != nil {
+		exitWithError(err.Error())
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		exitWithError(fmt.Sprintf("unable to get hostname: %s", err.Error()))
+	}
+	if *workerID == "" {
+		*workerID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	if err := gearcmd.ValidateEnvMode(*envMode); err != nil {
+		exitWithError(err.Error())
+	}
+	if err := gearcmd.ValidateEnv(env); err != nil {
+		exitWithError(err.Error())
+	}
+	var envAllowList []string
+	for _, name := range strings.Split(*envAllow, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			envAllowList = append(envAllowList, name)
+		}
+	}
+	if len(envAllowList) > 0 && *envMode != gearcmd.EnvAllowlist {
+		exitWithError("env-allow can only be used with -env-mode=allowlist")
+	}
+
+	var secrets []gearcmd.Secret
+	for _, mapping := range secretFlags {
+		secret, err := gearcmd.ParseSecret(mapping)
+		if err != nil {
+			exitWithError(err.Error())
+		}
+		secrets = append(secrets, secret)
+	}
+
+	var credential *syscall.Credential
+	if *runAs != "" {
+		if credential, err = gearcmd.LookupCredential(*runAs); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+	rlimits, err := procinit.ParseRlimits(*rlimitsFlag)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	var cgroups *cgroup.Manager
+	if *cgroupRoot != "" {
+		limits := cgroup.Limits{MemoryMax: *cgroupMemoryMax, CPUMax: *cgroupCPUMax}
+		if cgroups, err = cgroup.Setup(*cgroupRoot, limits); err != nil {
+			exitWithError(fmt.Sprintf("unable to set up cgroups: %s", err.Error()))
+		}
+	} else if *cgroupMemoryMax != 0 || *cgroupCPUMax != 0 {
+		exitWithError("cgroup must be set with -cgroup-memory-max or -cgroup-cpu-max")
+	}
+	jobIDSource, err := gearcmd.ParseJobIDSource(*jobIDSourceFlag)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+	exitCodePolicy, err := gearcmd.ParseExitCodePolicy(*exitCodePolicyFlag)
+	if err != nil {
+		exitWithError(err.Error())
+	}
+
+	var argsPolicy *argspolicy.Policy
+	if *argsPolicyPath != "" {
+		if argsPolicy, err = argspolicy.Load(*argsPolicyPath); err != nil {
+			exitWithError(fmt.Sprintf("unable to load args policy: %s", err.Error()))
+		}
+	}
+
+	var jobWatchdog *watchdog.Watchdog
+	if *useWatchdog {
+		if jobWatchdog, err = watchdog.Start(); err != nil {
+			exitWithError(err.Error())
+		}
+	}
+
+	stopSignal, err := gearcmd.ParseSignal(*stopSignalName)
+	if err != nil {
+		exitWithError(fmt.Sprintf("invalid stop-signal: %s", err.Error()))
+	}
+	forwardSignals, err := gearcmd.ParseForwardSignals(*forwardSignalNames)
+	if err != nil {
+		exitWithError(fmt.Sprintf("invalid forward-signals: %s", err.Error()))
+	}
+
 	config := gearcmd.TaskConfig{
 		FunctionName:            *functionName,
 		FunctionCmd:             *functionCmd,
+		WorkerID:                *workerID,
+		WorkerHostname:          hostname,
+		JobIDSource:             jobIDSource,
+		EnvMode:                 *envMode,
+		EnvAllow:                envAllowList,
+		Env:                     env,
+		SecretsDir:              *secretsDir,
+		Secrets:                 secrets,
 		WarningLines:            *warningLength,
+		LiveWarnings:            *liveWarnings,
+		LiveWarningInterval:     *liveWarningInterval,
 		ParseArgs:               *parseArgs,
+		JobLogs:                 *jobLogs,
+		JobLogDir:               *jobLogDir,
+		JobLogMaxFiles:          *jobLogMaxFiles,
+		JobLogMaxAge:            *jobLogMaxAge,
+		OutputFraming:           *outputFraming,
+		OutputEncoding:          *outputEncoding,
+		OutputBatchSize:         *outputBatchSize,
+		OutputFlushInterval:     *outputFlushInterval,
+		MaxStdoutBytes:          *maxStdoutBytes,
+		MaxStderrBytes:          *maxStderrBytes,
+		OutputLimitPolicy:       *outputLimitPolicy,
+		ResultFile:              *resultFile,
+		ResultFileMaxSize:       *resultFileMaxSize,
+		WorkDirRoot:             *workDirRoot,
+		MinFreeSpace:            *minFreeSpace,
+		FreeSpaceCheckInterval:  *freeSpaceCheckInterval,
+		KeepWorkDirOnFailure:    *keepWorkDirOnFailure,
+		FailedWorkDirRoot:       *failedWorkDirRoot,
+		FailedWorkDirMaxCount:   *failedWorkDirMaxCount,
+		FailedWorkDirMaxBytes:   *failedWorkDirMaxBytes,
+		Progress:                *progress,
+		ProgressInterval:        *progressInterval,
+		ArgsPolicy:              argsPolicy,
+		ExitCodePolicy:          exitCodePolicy,
+		Rlimits:                 rlimits,
+		Cgroups:                 cgroups,
+		RunAs:                   credential,
+		Sandbox:                 *sandbox,
+		Watchdog:                jobWatchdog,
 		CmdTimeout:              *cmdTimeout,
+		JobDeadline:             *jobDeadline,
 		RetryCount:              *retryCount,
+		RetryBackoff:            *retryBackoff,
+		RetryBackoffMultiplier:  *retryBackoffMultiplier,
+		RetryBackoffMax:         *retryBackoffMax,
+		RetryBackoffJitter:      *retryBackoffJitter,
 		Halt:                    make(chan struct{}),
 		LastResults:             ring.New(*errorBackoffCount),
 		ErrorResultsBackoffRate: *errorBackoffRate,
 		SigtermGracePeriod:      *sigtermGracePeriod,
+		StopSignal:              stopSignal,
+	}
+	if err := config.SweepWorkDirs(); err != nil {
+		lg.ErrorD("work-dir-sweep-failure", logger.M{"error": err.Error()})
+	}
+	if err := config.CheckSandbox(); err != nil {
+		exitWithError(err.Error())
+	}
+	// so processes the commands leave behind can be killed once they exit
+	if err := gearcmd.BecomeSubreaper(); err != nil {
+		lg.ErrorD("subreaper-failure", logger.M{"error": err.Error()})
 	}
 	worker := baseworker.NewWorker(*functionName, config.ProcessWithErrorBackoff)
 	defer worker.Close()
@@ -86,7 +338,19 @@ func main() {
 		worker.Shutdown()
 		os.Exit(0)
 	}()
+	if len(forwardSignals) > 0 {
+		forwardc := make(chan os.Signal, 1)
+		for _, forwardSignal := range forwardSignals {
+			signal.Notify(forwardc, forwardSignal)
+		}
+		go func() {
+			for sig := range forwardc {
+				config.ForwardSignal(sig.(syscall.Signal))
+			}
+		}()
+	}
 
+	config.WaitForFreeSpace()
 	lg.InfoD("listening", logger.M{"job": *functionName})
 	if err := worker.Listen(*gearmanHost, *gearmanPort); err != nil {
 		lg.CriticalD("failure-case", logger.M{"error": err.Error()})
@@ -0,0 +1,85 @@
+package gearcmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Clever/gearcmd/baseworker"
+)
+
+// Which of gearcmd's own environment variables the command gets.
+const (
+	// EnvInherit passes all of them.
+	EnvInherit = "inherit"
+	// EnvAllowlist only passes the ones in EnvAllow.
+	EnvAllowlist = "allowlist"
+	// EnvClean passes none of them.
+	EnvClean = "clean"
+)
+
+// ValidateEnvMode returns an error if mode isn't one of the supported env modes.
+func ValidateEnvMode(mode string) error {
+	switch mode {
+	case "", EnvInherit, EnvAllowlist, EnvClean:
+		return nil
+	}
+	return fmt.Errorf("unknown env mode %q, must be one of %s, %s or %s", mode, EnvInherit, EnvAllowlist, EnvClean)
+}
+
+// ValidateEnv returns an error if an entry of env isn't of the form KEY=VALUE.
+func ValidateEnv(env []string) error {
+	for _, entry := range env {
+		if i := strings.Index(entry, "="); i <= 0 {
+			return fmt.Errorf("env var %q isn't of the form KEY=VALUE", entry)
+		}
+	}
+	return nil
+}
+
+// commandEnv returns the environment the command starts from according to EnvMode, followed
+// by the Env additions. The variables gearcmd sets for the job come after it.
+func (conf *TaskConfig) commandEnv() []string {
+	var env []string
+	switch conf.EnvMode {
+	case EnvAllowlist:
+		for _, entry := range os.Environ() {
+			if conf.envAllowed(strings.SplitN(entry, "=", 2)[0]) {
+				env = append(env, entry)
+			}
+		}
+	case EnvClean:
+	default:
+		env = os.Environ()
+	}
+	return append(env, conf.Env...)
+}
+
+// envAllowed returns whether name is in EnvAllow, which has names and prefixes ending in "*".
+func (conf *TaskConfig) envAllowed(name string) bool {
+	for _, allowed := range conf.EnvAllow {
+		if strings.HasSuffix(allowed, "*") {
+			if strings.HasPrefix(name, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		} else if name == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// jobEnv returns the environment variables that tell the command about the job and the
+// try it's running.
+func (conf *TaskConfig) jobEnv(job baseworker.Job, jobID string, try int) []string {
+	return []string{
+		fmt.Sprintf("JOB_ID=%s", jobID),
+		fmt.Sprintf("GEARMAN_FUNCTION=%s", conf.FunctionName),
+		fmt.Sprintf("GEARMAN_HANDLE=%s", job.Handle()),
+		fmt.Sprintf("GEARMAN_UNIQUE_ID=%s", job.UniqueId()),
+		fmt.Sprintf("TRY_NUMBER=%d", try+1),
+		fmt.Sprintf("MAX_TRIES=%d", conf.RetryCount+1),
+		fmt.Sprintf("WORKER_ID=%s", conf.WorkerID),
+		fmt.Sprintf("WORKER_HOSTNAME=%s", conf.WorkerHostname),
+	}
+}
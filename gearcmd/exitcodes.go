@@ -0,0 +1,125 @@
+package gearcmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Clever/gearcmd/baseworker"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// How an exit code can be classified by an ExitCodePolicy.
+const (
+	// ExitRetry fails the try, and the job is retried if it has tries left.
+	ExitRetry = "retry"
+	// ExitFail fails the job without retrying it.
+	ExitFail = "fail"
+	// ExitSuccess treats the try as successful, and sends a warning with the exit code.
+	ExitSuccess = "success"
+)
+
+type exitCodeRule struct {
+	low, high      int
+	classification string
+}
+
+// ExitCodePolicy classifies the command's non-zero exit codes. Exit codes it doesn't match,
+// and commands killed by a signal, are retried.
+type ExitCodePolicy []exitCodeRule
+
+// ParseExitCodePolicy parses a comma separated list of <code>=<classification> or
+// <low>-<high>=<classification> rules, e.g. "75=retry,64-70=fail,3=success". The first rule
+// matching an exit code is used.
+func ParseExitCodePolicy(policy string) (ExitCodePolicy, error) {
+	var rules ExitCodePolicy
+	if strings.TrimSpace(policy) == "" {
+		return rules, nil
+	}
+	for _, rule := range strings.Split(policy, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("exit code rule %q isn't of the form <codes>=<classification>", rule)
+		}
+		switch parts[1] {
+		case ExitRetry, ExitFail, ExitSuccess:
+		default:
+			return nil, fmt.Errorf("unknown exit code classification %q, must be one of %s, %s or %s",
+				parts[1], ExitRetry, ExitFail, ExitSuccess)
+		}
+		codes := strings.SplitN(parts[0], "-", 2)
+		low, err := parseExitCode(codes[0])
+		if err != nil {
+			return nil, err
+		}
+		high := low
+		if len(codes) == 2 {
+			if high, err = parseExitCode(codes[1]); err != nil {
+				return nil, err
+			}
+		}
+		if low > high {
+			return nil, fmt.Errorf("exit code range %q is empty", parts[0])
+		}
+		rules = append(rules, exitCodeRule{low: low, high: high, classification: parts[1]})
+	}
+	return rules, nil
+}
+
+func parseExitCode(code string) (int, error) {
+	n, err := strconv.Atoi(code)
+	if err != nil || n < 1 || n > 255 {
+		return 0, fmt.Errorf("exit code %q must be a number from 1 to 255", code)
+	}
+	return n, nil
+}
+
+// classify returns the classification of exitCode, and false if no rule matches it.
+func (p ExitCodePolicy) classify(exitCode int) (string, bool) {
+	for _, rule := range p {
+		if exitCode >= rule.low && exitCode <= rule.high {
+			return rule.classification, true
+		}
+	}
+	return "", false
+}
+
+// exitCode returns the exit code of the command that returned err, and false if err isn't
+// from a command that exited by itself.
+func exitCode(err error) (int, bool) {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Exited() {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
+
+// classifyExit applies the ExitCodePolicy to the error a try returned. It returns whether the
+// job can be retried, and the try's error, which is nil if the exit code is treated as
+// success. The matched classification is added to info.
+func (conf *TaskConfig) classifyExit(job baseworker.Job, err error, info logger.M) (bool, error) {
+	code, ok := exitCode(err)
+	if !ok {
+		return true, err
+	}
+	classification, ok := conf.ExitCodePolicy.classify(code)
+	if !ok {
+		return true, err
+	}
+	info["exit_code"] = code
+	info["classification"] = classification
+	switch classification {
+	case ExitSuccess:
+		job.SendWarning([]byte(fmt.Sprintf("command exited with %d, which is treated as success\n", code)))
+		return true, nil
+	case ExitFail:
+		return false, err
+	}
+	return true, err
+}
@@ -0,0 +1,29 @@
+package gearcmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseExitCodePolicy(t *testing.T) {
+	policy, err := ParseExitCodePolicy("75=retry, 64-70=fail,3=success,1-255=fail")
+	assert.NoError(t, err)
+	for code, expected := range map[int]string{75: ExitRetry, 64: ExitFail, 70: ExitFail, 3: ExitSuccess, 2: ExitFail} {
+		classification, ok := policy.classify(code)
+		assert.True(t, ok)
+		assert.Equal(t, expected, classification, "exit code %d", code)
+	}
+
+	policy, err = ParseExitCodePolicy("")
+	assert.NoError(t, err)
+	_, ok := policy.classify(1)
+	assert.False(t, ok)
+}
+
+func TestParseExitCodePolicyInvalid(t *testing.T) {
+	for _, policy := range []string{"75", "75=ignore", "0=success", "256=fail", "a=fail", "70-64=fail", "64-=fail"} {
+		_, err := ParseExitCodePolicy(policy)
+		assert.Error(t, err, policy)
+	}
+}
@@ -0,0 +1,92 @@
+package gearcmd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Clever/gearcmd/baseworker"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// Where the job ID is taken from.
+const (
+	// JobIDHandleSuffix uses whatever is after the last ':' in the job handle. This is meant for
+	// gearman-admin, which adds a random job ID to the handle.
+	JobIDHandleSuffix = "handle-suffix"
+	// JobIDUniqueID uses the unique ID the job was submitted with.
+	JobIDUniqueID = "unique-id"
+	// JobIDUUID generates a random UUID for every job.
+	JobIDUUID = "uuid"
+	// jobIDRegexPrefix is followed by a regular expression whose first capture group is taken
+	// from the job handle.
+	jobIDRegexPrefix = "regex:"
+)
+
+// JobIDSource gets a job's ID. The zero value uses JobIDHandleSuffix.
+type JobIDSource struct {
+	source  string
+	pattern *regexp.Regexp
+}
+
+// ParseJobIDSource parses handle-suffix, unique-id, uuid or regex:<expression>, where the
+// expression has a capture group for the ID.
+func ParseJobIDSource(source string) (JobIDSource, error) {
+	switch source {
+	case "", JobIDHandleSuffix:
+		return JobIDSource{source: JobIDHandleSuffix}, nil
+	case JobIDUniqueID, JobIDUUID:
+		return JobIDSource{source: source}, nil
+	}
+	if !strings.HasPrefix(source, jobIDRegexPrefix) {
+		return JobIDSource{}, fmt.Errorf("unknown job ID source %q, must be one of %s, %s, %s or %s<expression>",
+			source, JobIDHandleSuffix, JobIDUniqueID, JobIDUUID, jobIDRegexPrefix)
+	}
+	pattern, err := regexp.Compile(strings.TrimPrefix(source, jobIDRegexPrefix))
+	if err != nil {
+		return JobIDSource{}, fmt.Errorf("invalid job ID expression: %s", err.Error())
+	}
+	if pattern.NumSubexp() < 1 {
+		return JobIDSource{}, fmt.Errorf("job ID expression %q has no capture group", pattern.String())
+	}
+	return JobIDSource{source: jobIDRegexPrefix, pattern: pattern}, nil
+}
+
+// unsafeJobIDChars matches what's replaced in job IDs so they can be used in file names.
+var unsafeJobIDChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// jobID returns the job's ID. If the source doesn't give one, a UUID is generated.
+func (s JobIDSource) jobID(job baseworker.Job) string {
+	var id string
+	switch s.source {
+	case "", JobIDHandleSuffix:
+		id = getJobID(job)
+	case JobIDUniqueID:
+		id = job.UniqueId()
+	case jobIDRegexPrefix:
+		if match := s.pattern.FindStringSubmatch(job.Handle()); match != nil {
+			id = match[1]
+		}
+	}
+	id = unsafeJobIDChars.ReplaceAllString(id, "_")
+	if id == "" {
+		id = newUUID()
+		if s.source != JobIDUUID {
+			lg.InfoD("generated-job-id", logger.M{"msg": "no job id found, generated one.", "handle": job.Handle()})
+		}
+	}
+	return id
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		// crypto/rand only fails if the OS has no randomness to give, which we can't work without
+		panic(fmt.Sprintf("unable to generate UUID: %s", err.Error()))
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
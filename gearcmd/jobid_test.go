@@ -0,0 +1,50 @@
+package gearcmd
+
+import (
+	"regexp"
+	"testing"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestJobIDSources(t *testing.T) {
+	mockJob := &mock.Job{GearmanHandle: "H:lap:123", ID: "some/unique id"}
+	for source, expected := range map[string]string{
+		"":                   "123",
+		JobIDHandleSuffix:    "123",
+		JobIDUniqueID:        "some_unique_id",
+		`regex:^H:([^:]+):`:  "lap",
+		`regex:^H:(\w+):\d+`: "lap",
+	} {
+		jobIDSource, err := ParseJobIDSource(source)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, jobIDSource.jobID(mockJob), source)
+	}
+	// the zero value uses the handle suffix
+	assert.Equal(t, "123", JobIDSource{}.jobID(mockJob))
+
+	jobIDSource, err := ParseJobIDSource(JobIDUUID)
+	assert.NoError(t, err)
+	first, second := jobIDSource.jobID(mockJob), jobIDSource.jobID(mockJob)
+	assert.Regexp(t, uuidPattern, first)
+	assert.NotEqual(t, first, second)
+}
+
+func TestJobIDGeneratedWhenMissing(t *testing.T) {
+	mockJob := &mock.Job{GearmanHandle: "H:lap:"}
+	for _, source := range []string{JobIDHandleSuffix, JobIDUniqueID, `regex:^J:(\d+)$`} {
+		jobIDSource, err := ParseJobIDSource(source)
+		assert.NoError(t, err)
+		assert.Regexp(t, uuidPattern, jobIDSource.jobID(mockJob), source)
+	}
+}
+
+func TestParseJobIDSourceInvalid(t *testing.T) {
+	for _, source := range []string{"handle", "regex:(", "regex:^H:.*$"} {
+		_, err := ParseJobIDSource(source)
+		assert.Error(t, err, source)
+	}
+}
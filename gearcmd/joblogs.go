@@ -0,0 +1,155 @@
+package gearcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Clever/gearcmd/config"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// Where gearcmd writes a copy of the command's stdout and stderr.
+const (
+	// JobLogsPassthrough writes them to gearcmd's own stdout and stderr as is.
+	JobLogsPassthrough = "passthrough"
+	// JobLogsFiles writes them to one file per job, try and stream in the job log directory.
+	JobLogsFiles = "files"
+	// JobLogsPrefix writes them to gearcmd's own stdout and stderr, with every line prefixed
+	// by the job ID and stream.
+	JobLogsPrefix = "prefix"
+)
+
+// ValidateJobLogs returns an error if mode isn't one of the supported job log modes.
+func ValidateJobLogs(mode string) error {
+	switch mode {
+	case "", JobLogsPassthrough, JobLogsFiles, JobLogsPrefix:
+		return nil
+	}
+	return fmt.Errorf("unknown job logs mode %q, must be one of %s, %s or %s",
+		mode, JobLogsPassthrough, JobLogsFiles, JobLogsPrefix)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// jobLogWriters returns the writers the command's stdout and stderr are copied to for the
+// logs. They must be closed once the command has exited.
+func (conf *TaskConfig) jobLogWriters(jobID string, try int) (io.WriteCloser, io.WriteCloser, error) {
+	switch conf.JobLogs {
+	case JobLogsFiles:
+		conf.pruneJobLogs()
+		name := fmt.Sprintf("%s-%s-%d", conf.FunctionName, jobID, try)
+		stdout, err := os.Create(filepath.Join(conf.JobLogDir, name+".stdout.log"))
+		if err != nil {
+			return nil, nil, err
+		}
+		stderr, err := os.Create(filepath.Join(conf.JobLogDir, name+".stderr.log"))
+		if err != nil {
+			stdout.Close()
+			return nil, nil, err
+		}
+		return stdout, stderr, nil
+	case JobLogsPrefix:
+		return newPrefixWriter(os.Stdout, fmt.Sprintf("[%s stdout] ", jobID)),
+			newPrefixWriter(os.Stderr, fmt.Sprintf("[%s stderr] ", jobID)), nil
+	}
+	return nopCloser{os.Stdout}, nopCloser{os.Stderr}, nil
+}
+
+// pruneJobLogs removes this function's job log files that are older than JobLogMaxAge, and
+// the oldest ones beyond JobLogMaxFiles. Zero means no limit for either.
+func (conf *TaskConfig) pruneJobLogs() {
+	files, err := ioutil.ReadDir(conf.JobLogDir)
+	if err != nil {
+		lg.ErrorD("job-logs-prune-failure", logger.M{"dir": conf.JobLogDir, "error": err.Error()})
+		return
+	}
+	var logs []os.FileInfo
+	for _, file := range files {
+		if !file.IsDir() && strings.HasPrefix(file.Name(), conf.FunctionName+"-") &&
+			strings.HasSuffix(file.Name(), ".log") {
+			logs = append(logs, file)
+		}
+	}
+	// newest first
+	sort.Slice(logs, func(i, j int) bool { return logs[i].ModTime().After(logs[j].ModTime()) })
+	now := config.Clock.Now()
+	for i, file := range logs {
+		// keep room for the two files of the job that's about to run
+		tooMany := conf.JobLogMaxFiles > 0 && i >= conf.JobLogMaxFiles-2
+		tooOld := conf.JobLogMaxAge > 0 && now.Sub(file.ModTime()) > conf.JobLogMaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(filepath.Join(conf.JobLogDir, file.Name())); err != nil {
+			lg.ErrorD("job-logs-prune-failure", logger.M{"file": file.Name(), "error": err.Error()})
+		}
+	}
+}
+
+// prefixWriter writes every line written to it to w, prefixed by prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+// Write implements io.Writer. Complete lines are written right away.
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, data...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.partial[:i+1]); err != nil {
+			return 0, err
+		}
+		p.partial = p.partial[i+1:]
+	}
+	// don't hold on to too much of a line without a newline
+	if len(p.partial) >= maxStderrLineLength {
+		if err := p.writeLine(append(p.partial, '\n')); err != nil {
+			return 0, err
+		}
+		p.partial = nil
+	}
+	return len(data), nil
+}
+
+// writeLine must be called with p.mu held. The line is written with a single Write so that
+// lines of different streams don't get mixed up.
+func (p *prefixWriter) writeLine(line []byte) error {
+	_, err := p.w.Write(append(append([]byte{}, p.prefix...), line...))
+	return err
+}
+
+// Close writes an unterminated last line.
+func (p *prefixWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) == 0 {
+		return nil
+	}
+	err := p.writeLine(append(p.partial, '\n'))
+	p.partial = nil
+	return err
+}
@@ -0,0 +1,74 @@
+package gearcmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newPrefixWriter(&buf, "[123 stdout] ")
+	w.Write([]byte("line1\nli"))
+	w.Write([]byte("ne2\nline3"))
+	assert.Equal(t, "[123 stdout] line1\n[123 stdout] line2\n", buf.String())
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "[123 stdout] line1\n[123 stdout] line2\n[123 stdout] line3\n", buf.String())
+}
+
+func TestJobLogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "joblogs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	mockJob.GearmanHandle = "H:lap:123"
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/logStdoutAndStderr.sh",
+		WarningLines: 5,
+		JobLogs:      JobLogsFiles,
+		JobLogDir:    dir,
+	}
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	stdout, err := ioutil.ReadFile(filepath.Join(dir, "name-123-0.stdout.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "stdout1\nstdout2\n", string(stdout))
+	stderr, err := ioutil.ReadFile(filepath.Join(dir, "name-123-0.stderr.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "stderr1\nstderr2\n", string(stderr))
+	// the client still gets the output
+	assert.Equal(t, "stdout1\nstdout2\n", string(mockJob.OutData()))
+}
+
+func TestPruneJobLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "joblogs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	for i, name := range []string{"name-1-0.stdout.log", "name-2-0.stdout.log", "name-3-0.stdout.log",
+		"name-4-0.stdout.log", "other-1-0.stdout.log"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte{}, 0644))
+		modTime := now.Add(-time.Duration(10-i) * time.Hour)
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	// name-1 is too old, and only two files are kept to leave room for the next job's two
+	config := TaskConfig{FunctionName: "name", JobLogDir: dir, JobLogMaxFiles: 4, JobLogMaxAge: 9*time.Hour + 30*time.Minute}
+	config.pruneJobLogs()
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Equal(t, []string{"name-3-0.stdout.log", "name-4-0.stdout.log", "other-1-0.stdout.log"}, names)
+}
@@ -0,0 +1,74 @@
+package gearcmd
+
+import (
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/Clever/gearcmd/baseworker"
+	"github.com/Clever/gearcmd/cgroup"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// commandExited looks at how the command exited once Wait has returned err. Anything worth
+// adding to the job's END and RETRY events is added to info. It returns the try's error.
+func (conf *TaskConfig) commandExited(job baseworker.Job, cmd *exec.Cmd, group *cgroup.Group, err error,
+	info logger.M) error {
+	if cmd.ProcessState == nil {
+		return err
+	}
+	rusageInfo(cmd.ProcessState, info)
+	reason, message := conf.rlimitFailure(cmd)
+	if group != nil {
+		stats, statsErr := group.Stats()
+		if statsErr != nil {
+			lg.ErrorD("cgroup-stats-failure", logger.M{"function": conf.FunctionName, "error": statsErr.Error()})
+		} else {
+			info["cgroup_cpu_usage_ms"] = durationMillis(stats.CPUUsage)
+			info["cgroup_cpu_user_ms"] = durationMillis(stats.CPUUser)
+			info["cgroup_cpu_system_ms"] = durationMillis(stats.CPUSystem)
+			info["cgroup_memory_peak_bytes"] = stats.MemoryPeak
+			info["cgroup_oom_kills"] = stats.OOMKills
+			if stats.OOMKills > 0 && err != nil && reason == "" {
+				reason = "oom"
+				message = "killed by the out of memory killer"
+				if memoryMax := conf.Cgroups.Limits().MemoryMax; memoryMax > 0 {
+					message = fmt.Sprintf("killed for exceeding the memory limit of %d bytes", memoryMax)
+				}
+			}
+		}
+	}
+	if reason != "" {
+		info["failure_reason"] = reason
+		job.SendWarning([]byte(message + "\n"))
+		return fmt.Errorf("%s (%s)", message, err.Error())
+	}
+	return err
+}
+
+func durationMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// rlimitFailure returns why the command failed and a message saying so if it was because of
+// one of the Rlimits. Only the limits the kernel enforces with a signal can be told apart,
+// hitting the others makes system calls fail, which the command has to report itself.
+func (conf *TaskConfig) rlimitFailure(cmd *exec.Cmd) (string, string) {
+	status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return "", ""
+	}
+	for _, rlimit := range conf.Rlimits {
+		switch {
+		case rlimit.Name == "cpu" && (status.Signal() == syscall.SIGXCPU ||
+			// past the soft limit, the process is killed at the hard limit a second later
+			status.Signal() == syscall.SIGKILL &&
+				cmd.ProcessState.UserTime()+cmd.ProcessState.SystemTime() >= time.Duration(rlimit.Value)*time.Second):
+			return "rlimit_cpu", fmt.Sprintf("exceeded the CPU time limit of %d seconds", rlimit.Value)
+		case rlimit.Name == "fsize" && status.Signal() == syscall.SIGXFSZ:
+			return "rlimit_fsize", fmt.Sprintf("exceeded the file size limit of %d bytes", rlimit.Value)
+		}
+	}
+	return "", ""
+}
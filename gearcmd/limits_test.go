@@ -0,0 +1,91 @@
+package gearcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/Clever/gearcmd/cgroup"
+	"github.com/Clever/gearcmd/procinit"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+func TestRlimitCPU(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/burnCPU.sh",
+		Rlimits:      []procinit.Rlimit{{Name: "cpu", Value: 1}},
+	}
+	_, err := config.Process(mockJob)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exceeded the CPU time limit of 1 seconds")
+	}
+	warnings := []string{}
+	for _, warning := range mockJob.Warnings() {
+		warnings = append(warnings, string(warning))
+	}
+	assert.Contains(t, warnings, "exceeded the CPU time limit of 1 seconds\n")
+}
+
+func TestRlimitFileSize(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/writeLargeFile.sh",
+		Rlimits:      []procinit.Rlimit{{Name: "fsize", Value: 1000}},
+	}
+	_, err := config.Process(mockJob)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exceeded the file size limit of 1000 bytes")
+	}
+}
+
+func TestRlimitsNotHit(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/writeLargeFile.sh",
+		Rlimits:      []procinit.Rlimit{{Name: "fsize", Value: 4096}, {Name: "nofile", Value: 64}},
+	}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+}
+
+func TestCgroupStatsReported(t *testing.T) {
+	var root string
+	for _, mountPoint := range []string{"/sys/fs/cgroup/unified", "/sys/fs/cgroup"} {
+		dir := filepath.Join(mountPoint, fmt.Sprintf("gearcmd-test-%d", os.Getpid()))
+		if err := os.Mkdir(dir, 0755); err == nil {
+			root = dir
+			break
+		}
+	}
+	if root == "" {
+		t.Skip("can't create a cgroup")
+	}
+	defer os.Remove(root)
+	cgroups, err := cgroup.Setup(root, cgroup.Limits{})
+	if err != nil {
+		t.Skipf("%s isn't a usable cgroup: %s", root, err.Error())
+	}
+
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/success.sh", Cgroups: cgroups}
+	info := logger.M{}
+	assert.NoError(t, config.doProcess(mockJob, "123", nil, nil, "", 0, 0, info))
+	for _, key := range []string{"cgroup_cpu_usage_ms", "cgroup_memory_peak_bytes", "cgroup_oom_kills"} {
+		_, ok := info[key]
+		assert.True(t, ok, key)
+	}
+	// the job's group is gone once it's done
+	files, err := ioutil.ReadDir(root)
+	assert.NoError(t, err)
+	for _, file := range files {
+		assert.False(t, file.IsDir(), file.Name())
+	}
+}
@@ -0,0 +1,196 @@
+package gearcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Clever/gearcmd/baseworker"
+	"github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+)
+
+// The ways the command's stdout can be split into WORK_DATA packets.
+const (
+	// FramingRaw sends stdout as it's read, in packets of at most 1024 bytes.
+	FramingRaw = "raw"
+	// FramingLine sends one packet per complete line.
+	FramingLine = "line"
+	// FramingBatch collects stdout and sends it once the batch size is reached or the flush
+	// interval has passed since the oldest unsent byte was written. A zero interval only
+	// sends full batches, and the rest when the command exits.
+	FramingBatch = "batch"
+)
+
+// rawPacketSize is the largest packet sent with FramingRaw.
+const rawPacketSize = 1024
+
+// ValidateFraming returns an error if framing isn't one of the supported framings.
+func ValidateFraming(framing string) error {
+	switch framing {
+	case "", FramingRaw, FramingLine, FramingBatch:
+		return nil
+	}
+	return fmt.Errorf("unknown output framing %q, must be one of %s, %s or %s",
+		framing, FramingRaw, FramingLine, FramingBatch)
+}
+
+// dataWriter sends what's written to it to the job as WORK_DATA packets, split according to
+// its framing. Close must be called to send anything still buffered.
+type dataWriter struct {
+	job       baseworker.Job
+	framing   string
+	batchSize int
+	interval  time.Duration
+
+	mu         sync.Mutex
+	buf        []byte
+	flushTimer *clock.Timer
+}
+
+// newDataWriter creates a dataWriter. batchSize is the most that's buffered before sending
+// with FramingBatch, and the longest line sent as one packet with FramingLine.
+func newDataWriter(job baseworker.Job, framing string, batchSize int, interval time.Duration) *dataWriter {
+	if framing == "" {
+		framing = FramingRaw
+	}
+	if batchSize <= 0 {
+		batchSize = 64 * 1024
+	}
+	return &dataWriter{job: job, framing: framing, batchSize: batchSize, interval: interval}
+}
+
+// Write implements io.Writer.
+func (w *dataWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch w.framing {
+	case FramingRaw:
+		for data := p; len(data) > 0; {
+			n := len(data)
+			if n > rawPacketSize {
+				n = rawPacketSize
+			}
+			w.job.SendData(data[:n])
+			data = data[n:]
+		}
+	case FramingLine:
+		w.buf = append(w.buf, p...)
+		for {
+			i := bytes.IndexByte(w.buf, '\n')
+			if i < 0 && len(w.buf) < w.batchSize {
+				break
+			}
+			// send lines longer than the batch size in pieces so the buffer stays bounded
+			n := i + 1
+			if i < 0 || n > w.batchSize {
+				n = w.batchSize
+			}
+			w.send(n)
+		}
+	case FramingBatch:
+		w.buf = append(w.buf, p...)
+		sent := false
+		for len(w.buf) >= w.batchSize {
+			w.send(w.batchSize)
+			sent = true
+		}
+		if sent && w.flushTimer != nil {
+			// what's left was written after the bytes the timer was started for
+			w.flushTimer.Stop()
+			w.flushTimer = nil
+		}
+		if len(w.buf) > 0 && w.flushTimer == nil && w.interval > 0 {
+			w.flushTimer = config.Clock.AfterFunc(w.interval, w.flush)
+		}
+	}
+	return len(p), nil
+}
+
+// send sends the first n buffered bytes. It must be called with w.mu held.
+func (w *dataWriter) send(n int) {
+	w.job.SendData(w.buf[:n])
+	w.buf = append([]byte{}, w.buf[n:]...)
+}
+
+// flush sends everything that's buffered.
+func (w *dataWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+		w.flushTimer = nil
+	}
+	if len(w.buf) > 0 {
+		w.send(len(w.buf))
+	}
+}
+
+// Close sends everything that's buffered, including an unterminated last line.
+func (w *dataWriter) Close() error {
+	w.flush()
+	return nil
+}
+
+// What to do when the command writes more than the output limit.
+const (
+	// LimitPolicyTruncate drops the rest of the output and sends a warning.
+	LimitPolicyTruncate = "truncate"
+	// LimitPolicyFail kills the command and fails the try.
+	LimitPolicyFail = "fail"
+)
+
+// ValidateLimitPolicy returns an error if policy isn't one of the supported policies.
+func ValidateLimitPolicy(policy string) error {
+	switch policy {
+	case "", LimitPolicyTruncate, LimitPolicyFail:
+		return nil
+	}
+	return fmt.Errorf("unknown output limit policy %q, must be %s or %s", policy, LimitPolicyTruncate, LimitPolicyFail)
+}
+
+// limitWriter passes at most limit bytes of stream on to w and drops the rest. onExceeded is
+// called the first time more than limit bytes are written. A zero limit means no limit.
+type limitWriter struct {
+	w          io.Writer
+	stream     string
+	limit      int64
+	onExceeded func()
+
+	mu       sync.Mutex
+	written  int64
+	exceeded bool
+}
+
+// Write implements io.Writer. It never fails because of the limit, so that the command's
+// output keeps being drained.
+func (l *limitWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data := p
+	if l.limit > 0 {
+		if remaining := l.limit - l.written; int64(len(data)) > remaining {
+			data = data[:remaining]
+			if !l.exceeded {
+				l.exceeded = true
+				l.onExceeded()
+			}
+		}
+	}
+	l.written += int64(len(data))
+	if len(data) > 0 {
+		if _, err := l.w.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// wasExceeded returns whether more than limit bytes were written.
+func (l *limitWriter) wasExceeded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exceeded
+}
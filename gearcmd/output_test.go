@@ -0,0 +1,90 @@
+package gearcmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	gearcmdconfig "github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+// packetRecorder is a job that records every WORK_DATA packet separately.
+type packetRecorder struct {
+	*mock.Job
+	packets []string
+}
+
+func (p *packetRecorder) SendData(data []byte) {
+	p.packets = append(p.packets, string(data))
+	p.Job.SendData(data)
+}
+
+func newPacketRecorder() *packetRecorder {
+	return &packetRecorder{Job: mock.CreateMockJob("")}
+}
+
+func TestValidateFraming(t *testing.T) {
+	for _, framing := range []string{"", FramingRaw, FramingLine, FramingBatch} {
+		assert.NoError(t, ValidateFraming(framing))
+	}
+	assert.Error(t, ValidateFraming("json"))
+}
+
+func TestDataWriterRaw(t *testing.T) {
+	job := newPacketRecorder()
+	w := newDataWriter(job, FramingRaw, 0, 0)
+	w.Write(bytes.Repeat([]byte("a"), 2500))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, []int{1024, 1024, 452}, []int{len(job.packets[0]), len(job.packets[1]), len(job.packets[2])})
+}
+
+func TestDataWriterLine(t *testing.T) {
+	job := newPacketRecorder()
+	w := newDataWriter(job, FramingLine, 8, 0)
+	w.Write([]byte("{\"a\":"))
+	w.Write([]byte("1}\n{\"b\":2}\nlonger than eight\nlast"))
+	assert.Equal(t, []string{"{\"a\":1}\n", "{\"b\":2}\n", "longer t", "han eigh", "t\n"}, job.packets)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "last", job.packets[len(job.packets)-1])
+}
+
+func TestDataWriterBatch(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	job := newPacketRecorder()
+	w := newDataWriter(job, FramingBatch, 10, time.Second)
+	w.Write([]byte("12345"))
+	w.Write([]byte("67890abc"))
+	assert.Equal(t, []string{"1234567890"}, job.packets)
+
+	// the rest is sent once the flush interval passes
+	mockClock.Add(time.Second)
+	assert.Equal(t, []string{"1234567890", "abc"}, job.packets)
+
+	w.Write([]byte("de"))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, []string{"1234567890", "abc", "de"}, job.packets)
+}
+
+func TestLimitWriter(t *testing.T) {
+	var buf bytes.Buffer
+	exceeded := 0
+	l := &limitWriter{w: &buf, stream: "stdout", limit: 5, onExceeded: func() { exceeded++ }}
+	n, err := l.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.False(t, l.wasExceeded())
+	n, err = l.Write([]byte("defg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	l.Write([]byte("hij"))
+	assert.True(t, l.wasExceeded())
+	assert.Equal(t, 1, exceeded)
+	assert.Equal(t, "abcde", buf.String())
+}
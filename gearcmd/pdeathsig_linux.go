@@ -0,0 +1,9 @@
+package gearcmd
+
+import "syscall"
+
+// setParentDeathSignal makes the command get killed if the thread of gearcmd that started it
+// exits, which Go only does when gearcmd does.
+func setParentDeathSignal(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
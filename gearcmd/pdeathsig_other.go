@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package gearcmd
+
+import "syscall"
+
+// setParentDeathSignal does nothing, parent death signals are Linux only.
+func setParentDeathSignal(attr *syscall.SysProcAttr) {}
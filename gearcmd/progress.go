@@ -0,0 +1,110 @@
+package gearcmd
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Clever/gearcmd/baseworker"
+	"github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+)
+
+// progressFD is the file descriptor the command can write its progress to. 0-2 are
+// stdin, stdout and stderr, so the first of cmd.ExtraFiles is 3.
+const progressFD = 3
+
+// progressReporter forwards progress lines written by the command, in the form
+// "numerator/denominator", to the job as WORK_STATUS updates. At most one update is sent
+// per interval; the latest update is always sent eventually.
+type progressReporter struct {
+	job      baseworker.Job
+	interval time.Duration
+
+	mu                     sync.Mutex
+	numerator, denominator int
+	received               bool
+	pending                bool
+	lastSent               time.Time
+	flushTimer             *clock.Timer
+}
+
+func newProgressReporter(job baseworker.Job, interval time.Duration) *progressReporter {
+	return &progressReporter{job: job, interval: interval}
+}
+
+// consume reads progress lines until reader is closed, then sends any pending update.
+func (p *progressReporter) consume(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		numerator, denominator, ok := parseProgress(scanner.Text())
+		if !ok {
+			continue
+		}
+		p.update(numerator, denominator)
+	}
+	p.flush()
+	return scanner.Err()
+}
+
+// update records the latest progress and sends it if the rate limit allows.
+func (p *progressReporter) update(numerator, denominator int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.numerator, p.denominator = numerator, denominator
+	p.received = true
+	p.pending = true
+	wait := p.interval - config.Clock.Now().Sub(p.lastSent)
+	if p.lastSent.IsZero() || wait <= 0 {
+		p.send()
+	} else if p.flushTimer == nil {
+		p.flushTimer = config.Clock.AfterFunc(wait, p.flush)
+	}
+}
+
+// flush sends the latest progress if it hasn't been sent yet.
+func (p *progressReporter) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.flushTimer != nil {
+		p.flushTimer.Stop()
+		p.flushTimer = nil
+	}
+	if p.pending {
+		p.send()
+	}
+}
+
+// send must be called with p.mu held.
+func (p *progressReporter) send() {
+	p.job.UpdateStatus(p.numerator, p.denominator)
+	p.pending = false
+	p.lastSent = config.Clock.Now()
+}
+
+// current returns the latest progress, and false if the command hasn't reported any.
+func (p *progressReporter) current() (int, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numerator, p.denominator, p.received
+}
+
+// parseProgress parses a "numerator/denominator" line, where 0 <= numerator <= denominator.
+func parseProgress(line string) (int, int, bool) {
+	parts := strings.SplitN(strings.TrimSpace(line), "/", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	numerator, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	denominator, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || denominator <= 0 || numerator < 0 || numerator > denominator {
+		return 0, 0, false
+	}
+	return numerator, denominator, true
+}
@@ -0,0 +1,59 @@
+package gearcmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	gearcmdconfig "github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProgress(t *testing.T) {
+	numerator, denominator, ok := parseProgress(" 3 / 10\n")
+	assert.True(t, ok)
+	assert.Equal(t, 3, numerator)
+	assert.Equal(t, 10, denominator)
+
+	for _, line := range []string{"", "50%", "a/b", "1/0", "-1/5", "12/10", "1/2/3"} {
+		_, _, ok := parseProgress(line)
+		assert.False(t, ok, line)
+	}
+}
+
+func TestProgressReporterRateLimited(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	mockJob := mock.CreateMockJob("")
+	progress := newProgressReporter(mockJob, time.Second)
+
+	_, _, ok := progress.current()
+	assert.False(t, ok)
+
+	// the first update is sent right away, the next ones wait for the interval
+	progress.update(1, 10)
+	assert.Equal(t, 1, mockJob.Numerator)
+	progress.update(2, 10)
+	progress.update(3, 10)
+	assert.Equal(t, 1, mockJob.Numerator)
+	numerator, _, ok := progress.current()
+	assert.True(t, ok)
+	assert.Equal(t, 3, numerator)
+
+	mockClock.Add(time.Second)
+	assert.Equal(t, 3, mockJob.Numerator)
+	assert.Equal(t, 10, mockJob.Denominator)
+}
+
+func TestProgressReporterFlushesOnClose(t *testing.T) {
+	mockJob := mock.CreateMockJob("")
+	progress := newProgressReporter(mockJob, time.Hour)
+	assert.NoError(t, progress.consume(strings.NewReader("1/4\nnot progress\n2/4\n3/4\n")))
+	assert.Equal(t, 3, mockJob.Numerator)
+	assert.Equal(t, 4, mockJob.Denominator)
+}
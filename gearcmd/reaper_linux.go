@@ -0,0 +1,168 @@
+package gearcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// prSetChildSubreaper isn't in the syscall package.
+const prSetChildSubreaper = 36
+
+// maxReapRounds bounds how many times killStragglers looks for processes left behind, in case
+// some can't be reaped, e.g. because they aren't gearcmd's children.
+const maxReapRounds = 100
+
+// BecomeSubreaper makes gearcmd the parent of the processes its commands leave behind when
+// their parent exits, e.g. daemonized ones, instead of init, so they can be found and killed
+// once the command exits.
+func BecomeSubreaper() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("unable to become a child subreaper: %s", errno.Error())
+	}
+	return nil
+}
+
+// killStragglers kills and reaps the processes left behind by the command that ran in process
+// group pgid once it exited: gearcmd's descendants besides the watchdog, and anything else still
+// in the group. It returns whether there were any.
+func (conf *TaskConfig) killStragglers(jobID string, pgid int) bool {
+	self := os.Getpid()
+	keep := 0
+	if conf.Watchdog != nil {
+		keep = conf.Watchdog.Pid()
+	}
+	killed := map[int]bool{}
+	for round := 0; round < maxReapRounds; round++ {
+		procs, err := processes()
+		if err != nil {
+			lg.ErrorD("straggler-search-failure", logger.M{"job_id": jobID, "error": err.Error()})
+			break
+		}
+		found := stragglers(procs, self, pgid, keep)
+		if len(found) == 0 {
+			break
+		}
+		progressed := false
+		for _, p := range found {
+			if p.state == 'Z' || killed[p.pid] {
+				continue
+			}
+			killed[p.pid] = true
+			progressed = true
+			lg.WarnD("straggler-killed", logger.M{
+				"function": conf.FunctionName,
+				"job_id":   jobID,
+				"pid":      p.pid,
+				"command":  p.command,
+			})
+			syscall.Kill(p.pid, syscall.SIGKILL)
+		}
+		// the children of the processes reaped here become gearcmd's, so they're reaped in a
+		// later round
+		for _, p := range found {
+			if p.ppid == self {
+				var status syscall.WaitStatus
+				syscall.Wait4(p.pid, &status, 0, nil)
+				progressed = true
+			}
+		}
+		if !progressed {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return len(killed) > 0
+}
+
+type process struct {
+	pid     int
+	ppid    int
+	pgid    int
+	state   byte
+	command string
+}
+
+// stragglers returns the descendants of the process self and the processes in group pgid,
+// besides the process keep and its descendants.
+func stragglers(procs []process, self, pgid, keep int) []process {
+	children := map[int][]process{}
+	for _, p := range procs {
+		children[p.ppid] = append(children[p.ppid], p)
+	}
+	var found []process
+	seen := map[int]bool{self: true, keep: true}
+	queue := []int{self}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			if !seen[child.pid] {
+				seen[child.pid] = true
+				found = append(found, child)
+				queue = append(queue, child.pid)
+			}
+		}
+	}
+	for _, p := range procs {
+		if p.pgid == pgid && !seen[p.pid] {
+			seen[p.pid] = true
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// processes returns the processes running on the machine, skipping those that exit while
+// they're being read.
+func processes() ([]process, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []process
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil {
+			continue
+		}
+		stat, err := ioutil.ReadFile("/proc/" + dir.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		if p, err := parseStat(string(stat)); err == nil {
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+// parseStat parses the start of /proc/<pid>/stat: "<pid> (<command>) <state> <ppid> <pgid> ...".
+// The command can contain spaces and parentheses, so it ends at the last ")".
+func parseStat(stat string) (process, error) {
+	open := strings.Index(stat, "(")
+	end := strings.LastIndex(stat, ")")
+	if open < 0 || end < open {
+		return process{}, fmt.Errorf("invalid stat %q", stat)
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 3 || len(fields[0]) != 1 {
+		return process{}, fmt.Errorf("invalid stat %q", stat)
+	}
+	p := process{command: stat[open+1 : end], state: fields[0][0]}
+	var err error
+	if p.pid, err = strconv.Atoi(strings.TrimSpace(stat[:open])); err != nil {
+		return process{}, err
+	}
+	if p.ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return process{}, err
+	}
+	if p.pgid, err = strconv.Atoi(fields[2]); err != nil {
+		return process{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,71 @@
+package gearcmd
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/Clever/gearcmd/watchdog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStragglersKilled(t *testing.T) {
+	assert.NoError(t, BecomeSubreaper())
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/leaveProcesses.sh", CmdTimeout: 10 * time.Second}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	pids := strings.Fields(string(mockJob.OutData()))
+	assert.Len(t, pids, 2)
+	for _, pid := range pids {
+		pid, err := strconv.Atoi(pid)
+		assert.NoError(t, err)
+		// killed and reaped
+		assert.Equal(t, syscall.ESRCH, syscall.Kill(pid, 0))
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	p, err := parseStat("42 (a (weird) name) S 1 40 40 0 -1 4194560 101 0 0 0")
+	assert.NoError(t, err)
+	assert.Equal(t, process{pid: 42, ppid: 1, pgid: 40, state: 'S', command: "a (weird) name"}, p)
+
+	_, err = parseStat("42 a S 1 40")
+	assert.Error(t, err)
+}
+
+func TestStragglers(t *testing.T) {
+	procs := []process{
+		{pid: 1, ppid: 0, pgid: 1},
+		{pid: 10, ppid: 1, pgid: 10},
+		{pid: 11, ppid: 10, pgid: 11},
+		{pid: 12, ppid: 11, pgid: 12},
+		{pid: 20, ppid: 1, pgid: 30},
+		{pid: 21, ppid: 1, pgid: 21},
+	}
+	var pids []int
+	for _, p := range stragglers(procs, 10, 30, 0) {
+		pids = append(pids, p.pid)
+	}
+	assert.Equal(t, []int{11, 12, 20}, pids)
+
+	pids = nil
+	for _, p := range stragglers(procs, 10, 30, 11) {
+		pids = append(pids, p.pid)
+	}
+	assert.Equal(t, []int{20}, pids)
+}
+
+func TestWatchdogNotKilled(t *testing.T) {
+	assert.NoError(t, BecomeSubreaper())
+	w, err := watchdog.Start()
+	assert.NoError(t, err)
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/leaveProcesses.sh", Watchdog: w}
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.NoError(t, syscall.Kill(w.Pid(), 0))
+}
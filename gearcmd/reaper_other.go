@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package gearcmd
+
+import (
+	"syscall"
+
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// BecomeSubreaper does nothing, child subreapers are Linux only. Only the processes left in
+// the command's process group are killed once it exits.
+func BecomeSubreaper() error {
+	return nil
+}
+
+// killStragglers kills the processes left in process group pgid by the command that ran in it
+// once it exited. It returns whether there were any.
+func (conf *TaskConfig) killStragglers(jobID string, pgid int) bool {
+	if err := syscall.Kill(-pgid, 0); err != nil {
+		return false
+	}
+	lg.WarnD("straggler-killed", logger.M{"function": conf.FunctionName, "job_id": jobID, "pgid": pgid})
+	syscall.Kill(-pgid, syscall.SIGKILL)
+	return true
+}
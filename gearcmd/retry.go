@@ -0,0 +1,55 @@
+package gearcmd
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/Clever/gearcmd/config"
+)
+
+// ValidateRetryBackoff returns an error if the retry backoff settings can't be used.
+func ValidateRetryBackoff(multiplier, jitter float64) error {
+	if multiplier < 1 {
+		return fmt.Errorf("retry backoff multiplier must be at least 1, got %v", multiplier)
+	}
+	if jitter < 0 || jitter > 1 {
+		return fmt.Errorf("retry backoff jitter must be from 0 to 1, got %v", jitter)
+	}
+	return nil
+}
+
+// retryBackoff returns how long to wait before the try after the given one. The wait starts
+// at RetryBackoff and is multiplied by RetryBackoffMultiplier after every try, up to
+// RetryBackoffMax. RetryBackoffJitter randomly takes up to that fraction off it.
+func (conf *TaskConfig) retryBackoff(try int) time.Duration {
+	if conf.RetryBackoff <= 0 {
+		return 0
+	}
+	backoff := float64(conf.RetryBackoff)
+	for i := 0; i < try; i++ {
+		backoff *= conf.RetryBackoffMultiplier
+		if conf.RetryBackoffMax > 0 && backoff >= float64(conf.RetryBackoffMax) {
+			break
+		}
+	}
+	if conf.RetryBackoffMax > 0 && backoff > float64(conf.RetryBackoffMax) {
+		backoff = float64(conf.RetryBackoffMax)
+	}
+	backoff -= backoff * conf.RetryBackoffJitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// waitToRetry waits for backoff. It returns false if Halt was closed while waiting, in which
+// case the job shouldn't be retried.
+func (conf *TaskConfig) waitToRetry(backoff time.Duration) bool {
+	if backoff <= 0 {
+		return true
+	}
+	select {
+	case <-conf.Halt:
+		return false
+	case <-config.Clock.After(backoff):
+		return true
+	}
+}
@@ -0,0 +1,140 @@
+package gearcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	gearcmdconfig "github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	config := TaskConfig{RetryBackoff: time.Second, RetryBackoffMultiplier: 3, RetryBackoffMax: 20 * time.Second}
+	assert.Equal(t, time.Second, config.retryBackoff(0))
+	assert.Equal(t, 3*time.Second, config.retryBackoff(1))
+	assert.Equal(t, 9*time.Second, config.retryBackoff(2))
+	assert.Equal(t, 20*time.Second, config.retryBackoff(3))
+	assert.Equal(t, 20*time.Second, config.retryBackoff(100))
+
+	config = TaskConfig{}
+	assert.Equal(t, time.Duration(0), config.retryBackoff(2))
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	config := TaskConfig{RetryBackoff: time.Second, RetryBackoffMultiplier: 1, RetryBackoffJitter: 0.5}
+	for i := 0; i < 100; i++ {
+		backoff := config.retryBackoff(0)
+		assert.True(t, backoff > 500*time.Millisecond && backoff <= time.Second, backoff.String())
+	}
+}
+
+func TestValidateRetryBackoff(t *testing.T) {
+	assert.NoError(t, ValidateRetryBackoff(1, 0))
+	assert.NoError(t, ValidateRetryBackoff(2, 1))
+	assert.Error(t, ValidateRetryBackoff(0.5, 0))
+	assert.Error(t, ValidateRetryBackoff(2, 1.5))
+}
+
+func TestProcessWaitsBetweenRetries(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	file, err := ioutil.TempFile("", "temp")
+	assert.NoError(t, err)
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer file.Close()
+
+	mockJob := mock.CreateMockJob(filename + " 1")
+	config := TaskConfig{
+		FunctionName:           "name",
+		FunctionCmd:            "testscripts/countRunsAndExit.sh",
+		ParseArgs:              true,
+		RetryCount:             1,
+		RetryBackoff:           time.Minute,
+		RetryBackoffMultiplier: 2,
+	}
+	done := make(chan error)
+	go func() {
+		_, err := config.Process(mockJob)
+		done <- err
+	}()
+	runs := func() string {
+		contents, err := ioutil.ReadFile(filename)
+		assert.NoError(t, err)
+		return string(contents)
+	}
+
+	// the retry doesn't run until the backoff has passed
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, "1\n", runs())
+	mockClock.Add(59 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "1\n", runs())
+	mockClock.Add(time.Second)
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "exit status 1")
+	case <-time.After(5 * time.Second):
+		t.Fatal("job wasn't retried after the backoff")
+	}
+	assert.Equal(t, "2\n", runs())
+}
+
+func TestWaitToRetryHalt(t *testing.T) {
+	config := TaskConfig{Halt: make(chan struct{})}
+	close(config.Halt)
+	assert.False(t, config.waitToRetry(time.Hour))
+	assert.True(t, config.waitToRetry(0))
+}
+
+func TestJobDeadlineStopsRetrying(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	file, err := ioutil.TempFile("", "temp")
+	assert.NoError(t, err)
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer file.Close()
+
+	// the first backoff would end after the deadline, so the job isn't retried
+	mockJob := mock.CreateMockJob(filename + " 1")
+	config := TaskConfig{
+		FunctionName:           "name",
+		FunctionCmd:            "testscripts/countRunsAndExit.sh",
+		ParseArgs:              true,
+		RetryCount:             5,
+		RetryBackoff:           time.Minute,
+		RetryBackoffMultiplier: 1,
+		JobDeadline:            30 * time.Second,
+	}
+	_, err = config.Process(mockJob)
+	assert.EqualError(t, err, "exit status 1")
+	contents, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", string(contents))
+}
+
+func TestJobDeadlineEnv(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/output_env.sh", JobDeadline: time.Hour}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	deadline := mockClock.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	assert.Contains(t, strings.Split(string(mockJob.OutData()), "\n"), "JOB_DEADLINE="+deadline)
+}
@@ -0,0 +1,75 @@
+package gearcmd
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// LookupCredential returns the credential to run the command with for runAs, a user or
+// user:group given by name or ID. Without a group the user's primary group is used. The
+// supplementary groups are the user's.
+func LookupCredential(runAs string) (*syscall.Credential, error) {
+	parts := strings.SplitN(runAs, ":", 2)
+	u, err := lookupUser(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has a non-numeric ID %q", parts[0], u.Uid)
+	}
+	gidString := u.Gid
+	if len(parts) == 2 {
+		g, err := lookupGroup(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		gidString = g.Gid
+	}
+	gid, err := strconv.ParseUint(gidString, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("group of %s has a non-numeric ID %q", runAs, gidString)
+	}
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		// without cgo this isn't implemented everywhere, then only the primary group is used
+		return credential, nil
+	}
+	for _, groupID := range groupIDs {
+		id, err := strconv.ParseUint(groupID, 10, 32)
+		if err == nil && uint32(id) != credential.Gid {
+			credential.Groups = append(credential.Groups, uint32(id))
+		}
+	}
+	return credential, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %q", name)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %q", name)
+	}
+	return g, nil
+}
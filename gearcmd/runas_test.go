@@ -0,0 +1,52 @@
+package gearcmd
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/Clever/gearcmd/procinit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCredential(t *testing.T) {
+	credential, err := LookupCredential("root")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), credential.Uid)
+	assert.Equal(t, uint32(0), credential.Gid)
+
+	credential, err = LookupCredential("0:0")
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0), credential.Uid)
+	assert.Equal(t, uint32(0), credential.Gid)
+
+	_, err = LookupCredential("no-such-user")
+	assert.EqualError(t, err, `unknown user "no-such-user"`)
+	_, err = LookupCredential("root:no-such-group")
+	assert.EqualError(t, err, `unknown group "no-such-group"`)
+}
+
+func TestRunAs(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("only root can run commands as another user")
+	}
+	credential, err := LookupCredential("nobody")
+	if err != nil {
+		t.Skip("there's no nobody user")
+	}
+	expected := fmt.Sprintf("%d:%d\n%d:%d\n", credential.Uid, credential.Gid, credential.Uid, credential.Gid)
+	for _, rlimits := range [][]procinit.Rlimit{nil, {{Name: "nofile", Value: 64}}} {
+		// with resource limits, the process drops privileges itself once it's set up
+		mockJob := mock.CreateMockJob("IgnorePayload")
+		config := TaskConfig{
+			FunctionName: "name",
+			FunctionCmd:  "testscripts/printIDs.sh",
+			RunAs:        credential,
+			Rlimits:      rlimits,
+		}
+		_, err = config.Process(mockJob)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(mockJob.OutData()))
+	}
+}
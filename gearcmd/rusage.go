@@ -0,0 +1,36 @@
+package gearcmd
+
+import (
+	"os"
+	"syscall"
+
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// rusageKeys are the fields rusageInfo adds, which are also logged in the duration event.
+var rusageKeys = []string{
+	"cpu_user_ms",
+	"cpu_system_ms",
+	"max_rss_bytes",
+	"block_input_ops",
+	"block_output_ops",
+	"voluntary_context_switches",
+	"involuntary_context_switches",
+}
+
+// rusageInfo adds the resources the command used, as reported by the kernel once it exited,
+// to info. Like the wait it comes from, it doesn't include processes the command started but
+// didn't wait for.
+func rusageInfo(state *os.ProcessState, info logger.M) {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return
+	}
+	info["cpu_user_ms"] = rusage.Utime.Nano() / 1e6
+	info["cpu_system_ms"] = rusage.Stime.Nano() / 1e6
+	info["max_rss_bytes"] = int64(rusage.Maxrss) * maxRSSUnit
+	info["block_input_ops"] = int64(rusage.Inblock)
+	info["block_output_ops"] = int64(rusage.Oublock)
+	info["voluntary_context_switches"] = int64(rusage.Nvcsw)
+	info["involuntary_context_switches"] = int64(rusage.Nivcsw)
+}
@@ -0,0 +1,4 @@
+package gearcmd
+
+// maxRSSUnit is the unit of ru_maxrss in bytes, which is bytes on macOS.
+const maxRSSUnit = 1
@@ -0,0 +1,7 @@
+//go:build !darwin
+// +build !darwin
+
+package gearcmd
+
+// maxRSSUnit is the unit of ru_maxrss in bytes, which is kilobytes on Linux and the BSDs.
+const maxRSSUnit = 1024
@@ -0,0 +1,24 @@
+package gearcmd
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+func TestRusageInfo(t *testing.T) {
+	cmd := exec.Command("testscripts/burnCPU.sh")
+	assert.NoError(t, cmd.Start())
+	cmd.Process.Kill()
+	cmd.Wait()
+	info := logger.M{}
+	rusageInfo(cmd.ProcessState, info)
+	for _, key := range rusageKeys {
+		_, ok := info[key]
+		assert.True(t, ok, key)
+	}
+	// even a shell uses more than a megabyte
+	assert.True(t, info["max_rss_bytes"].(int64) > 1024*1024)
+}
@@ -0,0 +1,26 @@
+package gearcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/Clever/gearcmd/procinit"
+)
+
+// CheckSandbox returns an error if Sandbox is set but commands can't be sandboxed, so that
+// gearcmd refuses to start instead of running them unsandboxed.
+func (conf *TaskConfig) CheckSandbox() error {
+	if !conf.Sandbox {
+		return nil
+	}
+	dir, err := ioutil.TempDir(conf.workDirRoot(), "gearcmd-sandbox-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := procinit.CheckSandbox(dir); err != nil {
+		return fmt.Errorf("unable to sandbox commands: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package gearcmd
+
+import (
+	"testing"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandbox(t *testing.T) {
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/printSandbox.sh", Sandbox: true}
+	if err := config.CheckSandbox(); err != nil {
+		t.Skip(err.Error())
+	}
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Equal(t, "pid 1\nwork dir writable\ntmpdir is work dir\ninterfaces lo\nNoNewPrivs:\t1\n", string(mockJob.OutData()))
+}
+
+func TestCheckSandboxDisabled(t *testing.T) {
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/success.sh"}
+	assert.NoError(t, config.CheckSandbox())
+}
@@ -0,0 +1,69 @@
+package gearcmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envVarName matches the names secrets can be injected as.
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Secret is a file whose contents are given to the command as the env var Var.
+type Secret struct {
+	File string
+	Var  string
+}
+
+// ParseSecret parses a <file>=<var> mapping.
+func ParseSecret(mapping string) (Secret, error) {
+	i := strings.LastIndex(mapping, "=")
+	if i <= 0 {
+		return Secret{}, fmt.Errorf("secret %q isn't of the form <file>=<var>", mapping)
+	}
+	secret := Secret{File: mapping[:i], Var: mapping[i+1:]}
+	if !envVarName.MatchString(secret.Var) {
+		return Secret{}, fmt.Errorf("secret %q has an invalid env var name", mapping)
+	}
+	return secret, nil
+}
+
+// secretsEnv reads the secrets in SecretsDir and Secrets, and returns them as env vars. A
+// file in SecretsDir is given as the env var named like the file, files whose names can't be
+// env var names are skipped. They're read for every job so that rotated secrets are picked
+// up. Errors never include a secret's contents.
+func (conf *TaskConfig) secretsEnv() ([]string, error) {
+	secrets := []Secret{}
+	if conf.SecretsDir != "" {
+		files, err := ioutil.ReadDir(conf.SecretsDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secrets dir: %s", err.Error())
+		}
+		for _, file := range files {
+			// e.g. Kubernetes keeps the files of a secret in a hidden directory it links to
+			if strings.HasPrefix(file.Name(), ".") || !envVarName.MatchString(file.Name()) {
+				continue
+			}
+			path := filepath.Join(conf.SecretsDir, file.Name())
+			if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			secrets = append(secrets, Secret{File: path, Var: file.Name()})
+		}
+	}
+	secrets = append(secrets, conf.Secrets...)
+
+	var env []string
+	for _, secret := range secrets {
+		contents, err := ioutil.ReadFile(secret.File)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read secret %s: %s", secret.Var, err.Error())
+		}
+		value := strings.TrimSuffix(strings.TrimSuffix(string(contents), "\n"), "\r")
+		env = append(env, fmt.Sprintf("%s=%s", secret.Var, value))
+	}
+	return env, nil
+}
@@ -0,0 +1,71 @@
+package gearcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecret(t *testing.T) {
+	secret, err := ParseSecret("/run/secrets/db=password=DB_PASSWORD")
+	assert.NoError(t, err)
+	assert.Equal(t, Secret{File: "/run/secrets/db=password", Var: "DB_PASSWORD"}, secret)
+
+	for _, mapping := range []string{"/run/secrets/db", "=DB_PASSWORD", "/run/secrets/db=", "/run/secrets/db=DB-PASSWORD"} {
+		_, err := ParseSecret(mapping)
+		assert.Error(t, err, mapping)
+	}
+}
+
+func TestSecretsInjected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for name, contents := range map[string]string{
+		"API_KEY":      "key\n",
+		"not-a-var":    "skipped",
+		".hidden":      "skipped",
+		"other-secret": "mapped",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600))
+	}
+
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/output_env.sh",
+		SecretsDir:   dir,
+		Secrets:      []Secret{{File: filepath.Join(dir, "other-secret"), Var: "OTHER"}},
+	}
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	env := strings.Split(string(mockJob.OutData()), "\n")
+	assert.Contains(t, env, "API_KEY=key")
+	assert.Contains(t, env, "OTHER=mapped")
+	assert.NotContains(t, string(mockJob.OutData()), "skipped")
+
+	// rotated secrets are picked up by the next job
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "API_KEY"), []byte("rotated"), 0600))
+	mockJob = mock.CreateMockJob("IgnorePayload")
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Contains(t, strings.Split(string(mockJob.OutData()), "\n"), "API_KEY=rotated")
+}
+
+func TestSecretMissing(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/output_env.sh",
+		RetryCount:   2,
+		Secrets:      []Secret{{File: "/nonexistent/secret", Var: "SECRET"}},
+	}
+	_, err := config.Process(mockJob)
+	assert.EqualError(t, err, "unable to read secret SECRET: open /nonexistent/secret: no such file or directory")
+	assert.Empty(t, mockJob.OutData())
+}
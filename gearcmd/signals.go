@@ -0,0 +1,87 @@
+package gearcmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// signalNames are the signals that can be sent to commands.
+var signalNames = map[string]syscall.Signal{
+	"ABRT":  syscall.SIGABRT,
+	"ALRM":  syscall.SIGALRM,
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"KILL":  syscall.SIGKILL,
+	"QUIT":  syscall.SIGQUIT,
+	"TERM":  syscall.SIGTERM,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// ParseSignal parses a signal name, with or without the SIG prefix, e.g. "SIGINT" or "int".
+func ParseSignal(name string) (syscall.Signal, error) {
+	upper := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	signal, ok := signalNames[upper]
+	if !ok {
+		var names []string
+		for name := range signalNames {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return 0, fmt.Errorf("unknown signal %q, must be one of %s", name, strings.Join(names, ", "))
+	}
+	return signal, nil
+}
+
+// ParseForwardSignals parses a comma separated list of signal names to forward to commands,
+// e.g. "HUP,USR1". SIGTERM and SIGINT stop gearcmd itself and SIGKILL can't be caught, so
+// they can't be forwarded.
+func ParseForwardSignals(names string) ([]syscall.Signal, error) {
+	var signals []syscall.Signal
+	if strings.TrimSpace(names) == "" {
+		return signals, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		signal, err := ParseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		switch signal {
+		case syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL:
+			return nil, fmt.Errorf("%s can't be forwarded", signal)
+		}
+		signals = append(signals, signal)
+	}
+	return signals, nil
+}
+
+// stopSignal returns the StopSignal, defaulting to SIGTERM.
+func (conf *TaskConfig) stopSignal() syscall.Signal {
+	if conf.StopSignal == 0 {
+		return syscall.SIGTERM
+	}
+	return conf.StopSignal
+}
+
+// ForwardSignal sends signal to the process group of the command that's running, if any.
+func (conf *TaskConfig) ForwardSignal(signal syscall.Signal) {
+	pgid := int(atomic.LoadInt32(&conf.runningPgid))
+	data := logger.M{"function": conf.FunctionName, "signal": signal.String()}
+	if pgid == 0 {
+		lg.InfoD("signal-not-forwarded", data)
+		return
+	}
+	data["pgid"] = pgid
+	if err := syscall.Kill(-pgid, signal); err != nil {
+		data["error"] = err.Error()
+		lg.ErrorD("signal-forward-failure", data)
+		return
+	}
+	lg.InfoD("signal-forwarded", data)
+}
@@ -0,0 +1,72 @@
+package gearcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"SIGINT", "int", " Int "} {
+		signal, err := ParseSignal(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, syscall.SIGINT, signal, name)
+	}
+	for _, name := range []string{"", "SIG", "SIGFOO", "2"} {
+		_, err := ParseSignal(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseForwardSignals(t *testing.T) {
+	signals, err := ParseForwardSignals("HUP, SIGUSR1,usr2")
+	assert.NoError(t, err)
+	assert.Equal(t, []syscall.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}, signals)
+
+	signals, err = ParseForwardSignals("")
+	assert.NoError(t, err)
+	assert.Empty(t, signals)
+
+	for _, names := range []string{"HUP,TERM", "INT", "KILL", "HUP,FOO"} {
+		_, err := ParseForwardSignals(names)
+		assert.Error(t, err, names)
+	}
+}
+
+func TestStopSignal(t *testing.T) {
+	config := TaskConfig{}
+	assert.Equal(t, syscall.SIGTERM, config.stopSignal())
+	config.StopSignal = syscall.SIGQUIT
+	assert.Equal(t, syscall.SIGQUIT, config.stopSignal())
+}
+
+func TestForwardSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gearcmd-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ready := filepath.Join(dir, "ready")
+	mockJob := mock.CreateMockJob(ready)
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/trapSignals.sh", CmdTimeout: 5 * time.Second}
+	// nothing is running yet, so it's dropped
+	config.ForwardSignal(syscall.SIGUSR1)
+	go func() {
+		for {
+			if _, err := os.Stat(ready); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		config.ForwardSignal(syscall.SIGHUP)
+		time.Sleep(200 * time.Millisecond)
+		config.ForwardSignal(syscall.SIGUSR1)
+	}()
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Equal(t, "ready\ngot HUP\ngot USR1\n", string(mockJob.OutData()))
+}
@@ -0,0 +1,177 @@
+package gearcmd
+
+import (
+	"bytes"
+	"container/ring"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Clever/gearcmd/baseworker"
+	"github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+)
+
+const (
+	// maxStderrLineLength is the longest stderr line we keep, the rest of a longer line is dropped.
+	maxStderrLineLength = 64 * 1024
+	// maxLiveWarningSize is the most stderr we hold on to while waiting to send a live warning.
+	// Older lines are dropped once it's reached.
+	maxLiveWarningSize = 64 * 1024
+)
+
+// stderrCapture processes the command's stderr line by line. It keeps the last warningLines
+// lines, which are sent as a warning when it's closed, and if live is set it also forwards
+// lines as warnings while the command runs, batching them to send at most one warning per
+// interval. Its memory use is bounded no matter how much the command writes.
+type stderrCapture struct {
+	job      baseworker.Job
+	live     bool
+	interval time.Duration
+
+	mu           sync.Mutex
+	lastLines    *ring.Ring
+	partial      []byte
+	truncating   bool
+	pending      [][]byte
+	pendingSize  int
+	droppedLines int
+	lastSent     time.Time
+	flushTimer   *clock.Timer
+	closed       bool
+}
+
+func newStderrCapture(job baseworker.Job, warningLines int, live bool, interval time.Duration) *stderrCapture {
+	return &stderrCapture{
+		job:       job,
+		live:      live,
+		interval:  interval,
+		lastLines: ring.New(warningLines),
+	}
+}
+
+// Write implements io.Writer.
+func (s *stderrCapture) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := p
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		chunk := data
+		if i >= 0 {
+			chunk = data[:i]
+		}
+		if !s.truncating {
+			if room := maxStderrLineLength - len(s.partial); len(chunk) > room {
+				chunk = chunk[:room]
+				s.truncating = true
+			}
+			s.partial = append(s.partial, chunk...)
+		}
+		if i < 0 {
+			break
+		}
+		s.addLine()
+		data = data[i+1:]
+	}
+	return len(p), nil
+}
+
+// addLine records the current line. It must be called with s.mu held.
+func (s *stderrCapture) addLine() {
+	line := s.partial
+	s.partial = nil
+	s.truncating = false
+	if s.lastLines != nil {
+		s.lastLines = s.lastLines.Next()
+		s.lastLines.Value = line
+	}
+	if !s.live || s.closed {
+		return
+	}
+	s.pending = append(s.pending, line)
+	s.pendingSize += len(line) + 1
+	for s.pendingSize > maxLiveWarningSize && len(s.pending) > 1 {
+		s.pendingSize -= len(s.pending[0]) + 1
+		s.pending = s.pending[1:]
+		s.droppedLines++
+	}
+	wait := s.interval - config.Clock.Now().Sub(s.lastSent)
+	if s.lastSent.IsZero() || wait <= 0 {
+		s.sendPending()
+	} else if s.flushTimer == nil {
+		s.flushTimer = config.Clock.AfterFunc(wait, s.flush)
+	}
+}
+
+// flush sends the lines waiting to be forwarded live.
+func (s *stderrCapture) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+	s.sendPending()
+}
+
+// sendPending must be called with s.mu held.
+func (s *stderrCapture) sendPending() {
+	if len(s.pending) == 0 {
+		return
+	}
+	warning := []byte{}
+	if s.droppedLines > 0 {
+		warning = append(warning, fmt.Sprintf("[%d stderr lines skipped]\n", s.droppedLines)...)
+	}
+	for _, line := range s.pending {
+		warning = append(warning, line...)
+		warning = append(warning, '\n')
+	}
+	s.job.SendWarning(warning)
+	s.pending = nil
+	s.pendingSize = 0
+	s.droppedLines = 0
+	s.lastSent = config.Clock.Now()
+}
+
+// Close records any unterminated last line, sends the lines still waiting to be forwarded
+// live and then sends the last warningLines lines as a warning.
+func (s *stderrCapture) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	if len(s.partial) > 0 {
+		s.addLine()
+	}
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+	s.sendPending()
+	s.closed = true
+
+	// Walk forward through the buffer to get all the last X entries. Note that we call next first
+	// so that we start at the oldest entry.
+	stderrbuf := []byte{}
+	for i := 0; i < s.lastLines.Len(); i++ {
+		if s.lastLines = s.lastLines.Next(); s.lastLines.Value != nil {
+			stderrbuf = append(stderrbuf, s.lastLines.Value.([]byte)...)
+			stderrbuf = append(stderrbuf, '\n')
+		}
+	}
+	s.job.SendWarning(stderrbuf)
+	return nil
+}
+
+// sendStderrWarnings sends the last X lines in the stderr output and to the job's warnings
+// field
+func sendStderrWarnings(buffer io.Reader, job baseworker.Job, warningLines int) error {
+	capture := newStderrCapture(job, warningLines, false, 0)
+	_, err := io.Copy(capture, buffer)
+	capture.Close()
+	return err
+}
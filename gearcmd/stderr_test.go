@@ -0,0 +1,74 @@
+package gearcmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	gearcmdconfig "github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStderrCaptureLinesSplitAcrossWrites(t *testing.T) {
+	mockJob := mock.CreateMockJob("")
+	capture := newStderrCapture(mockJob, 2, false, 0)
+	capture.Write([]byte("li"))
+	capture.Write([]byte("ne1\nline2\nli"))
+	capture.Write([]byte("ne3"))
+	assert.NoError(t, capture.Close())
+	assert.Equal(t, [][]byte{[]byte("line2\nline3\n")}, mockJob.Warnings())
+}
+
+func TestStderrCaptureTruncatesLongLines(t *testing.T) {
+	mockJob := mock.CreateMockJob("")
+	capture := newStderrCapture(mockJob, 1, false, 0)
+	long := bytes.Repeat([]byte("a"), 3*maxStderrLineLength)
+	capture.Write(long[:maxStderrLineLength/2])
+	capture.Write(long[maxStderrLineLength/2:])
+	capture.Write([]byte("\n"))
+	assert.NoError(t, capture.Close())
+	assert.Equal(t, maxStderrLineLength+1, len(mockJob.Warnings()[0]))
+}
+
+func TestStderrCaptureLiveWarnings(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	mockJob := mock.CreateMockJob("")
+	capture := newStderrCapture(mockJob, 1, true, time.Second)
+
+	// the first line is sent right away, the next ones are batched until the interval passes
+	capture.Write([]byte("line1\n"))
+	assert.Equal(t, [][]byte{[]byte("line1\n")}, mockJob.Warnings())
+	capture.Write([]byte("line2\nline3\n"))
+	assert.Equal(t, 1, len(mockJob.Warnings()))
+	mockClock.Add(time.Second)
+	assert.Equal(t, [][]byte{[]byte("line1\n"), []byte("line2\nline3\n")}, mockJob.Warnings())
+
+	// lines still waiting are sent on close, followed by the usual last lines
+	capture.Write([]byte("line4\n"))
+	assert.NoError(t, capture.Close())
+	assert.Equal(t, [][]byte{
+		[]byte("line1\n"), []byte("line2\nline3\n"), []byte("line4\n"), []byte("line4\n"),
+	}, mockJob.Warnings())
+}
+
+func TestStderrCaptureLiveWarningsBounded(t *testing.T) {
+	mockJob := mock.CreateMockJob("")
+	capture := newStderrCapture(mockJob, 1, true, time.Hour)
+	capture.Write([]byte("first\n"))
+	line := strings.Repeat("b", 1023) + "\n"
+	for i := 0; i < 100; i++ {
+		capture.Write([]byte(line))
+	}
+	assert.NoError(t, capture.Close())
+	warnings := mockJob.Warnings()
+	assert.Equal(t, 3, len(warnings))
+	assert.True(t, strings.HasPrefix(string(warnings[1]), "[36 stderr lines skipped]\n"))
+	assert.Equal(t, 64*1024+len("[36 stderr lines skipped]\n"), len(warnings[1]))
+}
@@ -0,0 +1,3 @@
+#!/bin/bash
+# This test runs until it's killed
+while :; do :; done
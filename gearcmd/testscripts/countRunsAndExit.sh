@@ -0,0 +1,6 @@
+#!/bin/bash
+# This test counts its runs in the input file arg and exits with the code given as the second arg
+read NUM_TIMES_RUN < $1
+((NUM_TIMES_RUN++))
+echo $NUM_TIMES_RUN > $1
+exit $2
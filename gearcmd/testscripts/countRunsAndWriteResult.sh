@@ -0,0 +1,6 @@
+#!/bin/bash
+# This test counts its runs in the input file arg and writes a result that's too large
+read NUM_TIMES_RUN < $1
+((NUM_TIMES_RUN++))
+echo $NUM_TIMES_RUN > $1
+echo -n "a result larger than five bytes" > $RESULT_FILE
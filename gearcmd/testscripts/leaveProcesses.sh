@@ -0,0 +1,7 @@
+#!/bin/bash
+# Leaves two processes running that hold its stdout: one in the background and one that
+# escapes its process group. Prints their pids.
+sleep 1000 &
+echo $!
+setsid sleep 1000 &
+echo $!
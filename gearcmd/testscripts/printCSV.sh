@@ -0,0 +1,7 @@
+#!/bin/bash
+# This test prints a large CSV to stdout
+echo "id,name"
+for i in $(seq 1 1000)
+do
+	echo "${i},name${i}"
+done
@@ -0,0 +1,6 @@
+#!/bin/bash
+# This test prints to stdout until it's killed
+while true
+do
+	echo "stdout"
+done
@@ -0,0 +1,5 @@
+#!/bin/bash
+# This test outputs who it runs as and who owns its work directory
+echo "$(id -u):$(id -g)"
+stat -c %u:%g $WORK_DIR
+touch $WORK_DIR/written
@@ -0,0 +1,5 @@
+#!/bin/bash
+# This test prints a few lines, the last one without a newline
+echo "line1"
+echo "line2"
+echo -n "line3"
@@ -0,0 +1,12 @@
+#!/bin/bash
+# Prints what a sandboxed command can see and do
+echo "pid $$"
+touch "$WORK_DIR/file" && echo "work dir writable"
+outside="$(dirname "$WORK_DIR")/sandbox-test-$$"
+if touch "$outside" 2>/dev/null; then
+  rm "$outside"
+  echo "outside writable"
+fi
+[ "$TMPDIR" = "$WORK_DIR" ] && echo "tmpdir is work dir"
+echo "interfaces $(tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' ')"
+grep NoNewPrivs /proc/self/status
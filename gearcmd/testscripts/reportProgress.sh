@@ -0,0 +1,7 @@
+#!/bin/bash
+# This test reports its progress through $PROGRESS_FD
+for i in 1 2 3
+do
+        echo "${i}/3" >&${PROGRESS_FD}
+done
+echo "done"
@@ -0,0 +1,7 @@
+#!/bin/bash
+# Prints the signals it gets for a second, touching $1 once it's ready for them
+trap 'echo got HUP' HUP
+trap 'echo got USR1' USR1
+echo ready
+touch "$1"
+for i in $(seq 10); do sleep 0.1; done
@@ -0,0 +1,3 @@
+#!/bin/bash
+# This test writes a 2000 byte file to its work directory
+exec head -c 2000 /dev/zero > $WORK_DIR/large
@@ -0,0 +1,4 @@
+#!/bin/bash
+# This test prints debug output and writes its result to the result file
+echo "some debug output"
+echo -n "the result" > $RESULT_FILE
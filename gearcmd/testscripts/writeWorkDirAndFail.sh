@@ -0,0 +1,3 @@
+#!/bin/bash
+echo "debug info" > "$WORK_DIR/debug.txt"
+exit 2
@@ -0,0 +1,214 @@
+package gearcmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/Clever/gearcmd/config"
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// defaultFreeSpaceCheckInterval is how often free space is checked while waiting for it when
+// FreeSpaceCheckInterval isn't set.
+const defaultFreeSpaceCheckInterval = 30 * time.Second
+
+// workDirRoot returns the directory work directories are created in. Without WorkDirRoot we
+// try to use MESOS_SANDBOX, the default will be the system temp directory.
+func (conf *TaskConfig) workDirRoot() string {
+	if conf.WorkDirRoot != "" {
+		return conf.WorkDirRoot
+	}
+	if sandbox := os.Getenv("MESOS_SANDBOX"); sandbox != "" {
+		return sandbox
+	}
+	return os.TempDir()
+}
+
+// SweepWorkDirs removes the work directories of this function left behind in the work
+// directory root, for example by a gearcmd that was killed. It must be called before any job
+// runs, and the root mustn't be shared with another gearcmd running the same function.
+func (conf *TaskConfig) SweepWorkDirs() error {
+	root := conf.workDirRoot()
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	// <function>-<job id>-<try>-<random suffix added by ioutil.TempDir>
+	workDirName := regexp.MustCompile("^" + regexp.QuoteMeta(conf.FunctionName) + `-.+-\d+-\d+$`)
+	for _, file := range files {
+		if !file.IsDir() || !workDirName.MatchString(file.Name()) {
+			continue
+		}
+		path := filepath.Join(root, file.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		lg.InfoD("leftover-work-dir-removed", logger.M{"work_dir": path})
+	}
+	return nil
+}
+
+// freeSpace is replaced in tests.
+var freeSpace = diskFreeSpace
+
+// diskFreeSpace returns the bytes available to unprivileged users on the volume holding path.
+func diskFreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// WaitForFreeSpace blocks until the volume holding the work directory root has at least
+// MinFreeSpace bytes available, or Halt is closed. It doesn't wait if free space can't be
+// determined.
+func (conf *TaskConfig) WaitForFreeSpace() {
+	if conf.MinFreeSpace <= 0 {
+		return
+	}
+	interval := conf.FreeSpaceCheckInterval
+	if interval <= 0 {
+		interval = defaultFreeSpaceCheckInterval
+	}
+	root := conf.workDirRoot()
+	for {
+		free, err := freeSpace(root)
+		if err != nil {
+			lg.ErrorD("free-space-check-failure", logger.M{"dir": root, "error": err.Error()})
+			return
+		}
+		if free >= uint64(conf.MinFreeSpace) {
+			return
+		}
+		lg.WarnD("low-free-space", logger.M{
+			"function":       conf.FunctionName,
+			"dir":            root,
+			"free_bytes":     free,
+			"min_free_bytes": conf.MinFreeSpace,
+		})
+		select {
+		case <-conf.Halt:
+			return
+		case <-config.Clock.After(interval):
+		}
+	}
+}
+
+// keepWorkDir moves a failed try's work directory into FailedWorkDirRoot, then removes the
+// oldest kept directories beyond FailedWorkDirMaxCount and FailedWorkDirMaxBytes. It returns
+// where the directory was kept, or "" if it couldn't be kept.
+func (conf *TaskConfig) keepWorkDir(workDir string) string {
+	keptPath := filepath.Join(conf.FailedWorkDirRoot, filepath.Base(workDir))
+	if err := os.Rename(workDir, keptPath); err != nil {
+		// the root may be on another device, in which case we have to copy
+		if err := copyTree(workDir, keptPath); err != nil {
+			lg.ErrorD("keep-work-dir-failure", logger.M{"work_dir": workDir, "error": err.Error()})
+			os.RemoveAll(keptPath)
+			os.RemoveAll(workDir)
+			return ""
+		}
+		os.RemoveAll(workDir)
+	}
+	conf.pruneKeptWorkDirs()
+	if _, err := os.Stat(keptPath); err != nil {
+		lg.WarnD("kept-work-dir-too-large", logger.M{"work_dir": keptPath})
+		return ""
+	}
+	return keptPath
+}
+
+// pruneKeptWorkDirs removes the oldest kept work directories until there are at most
+// FailedWorkDirMaxCount of them, using at most FailedWorkDirMaxBytes. Zero means no limit.
+func (conf *TaskConfig) pruneKeptWorkDirs() {
+	files, err := ioutil.ReadDir(conf.FailedWorkDirRoot)
+	if err != nil {
+		lg.ErrorD("kept-work-dirs-prune-failure", logger.M{"dir": conf.FailedWorkDirRoot, "error": err.Error()})
+		return
+	}
+	var dirs []os.FileInfo
+	for _, file := range files {
+		if file.IsDir() {
+			dirs = append(dirs, file)
+		}
+	}
+	// newest first
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].ModTime().After(dirs[j].ModTime()) })
+	var total int64
+	for i, dir := range dirs {
+		path := filepath.Join(conf.FailedWorkDirRoot, dir.Name())
+		total += treeSize(path)
+		tooMany := conf.FailedWorkDirMaxCount > 0 && i >= conf.FailedWorkDirMaxCount
+		tooLarge := conf.FailedWorkDirMaxBytes > 0 && total > conf.FailedWorkDirMaxBytes
+		if !tooMany && !tooLarge {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			lg.ErrorD("kept-work-dirs-prune-failure", logger.M{"dir": path, "error": err.Error()})
+		}
+	}
+}
+
+// treeSize returns the total size of the files under path.
+func treeSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// copyTree copies the directory src to dst, which mustn't exist yet.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		// skip sockets, pipes and devices
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("unable to copy %s: %s", src, err.Error())
+	}
+	return out.Close()
+}
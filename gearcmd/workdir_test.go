@@ -0,0 +1,179 @@
+package gearcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mock "github.com/Clever/gearcmd/baseworker/mock"
+	gearcmdconfig "github.com/Clever/gearcmd/config"
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepWorkDirOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failed")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	mockJob.GearmanHandle = "H:lap:123"
+	config := TaskConfig{
+		FunctionName:          "name",
+		FunctionCmd:           "testscripts/writeWorkDirAndFail.sh",
+		RetryCount:            1,
+		KeepWorkDirOnFailure:  true,
+		FailedWorkDirRoot:     dir,
+		FailedWorkDirMaxCount: 1,
+	}
+	_, err = config.Process(mockJob)
+	assert.Error(t, err)
+
+	// only the last try's directory is left
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.True(t, strings.HasPrefix(files[0].Name(), "name-123-1-"))
+		debug, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name(), "debug.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "debug info\n", string(debug))
+	}
+}
+
+func TestWorkDirRemovedOnSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failed")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName:         "name",
+		FunctionCmd:          "testscripts/success.sh",
+		KeepWorkDirOnFailure: true,
+		FailedWorkDirRoot:    dir,
+	}
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestPruneKeptWorkDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failed")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	for i, name := range []string{"old", "middle", "new"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.Mkdir(path, 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(path, "data"), make([]byte, 100), 0644))
+		modTime := now.Add(-time.Duration(10-i) * time.Hour)
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	// the two newest directories are too large together
+	config := TaskConfig{FailedWorkDirRoot: dir, FailedWorkDirMaxBytes: 150}
+	config.pruneKeptWorkDirs()
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, "new", files[0].Name())
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src, err := ioutil.TempDir("", "src")
+	assert.NoError(t, err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "dst")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dst)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(src, "sub"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(src, "sub", "file"), []byte("contents"), 0600))
+	assert.NoError(t, os.Symlink("sub/file", filepath.Join(src, "link")))
+
+	target := filepath.Join(dst, "copy")
+	assert.NoError(t, copyTree(src, target))
+	contents, err := ioutil.ReadFile(filepath.Join(target, "sub", "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, "contents", string(contents))
+	link, err := os.Readlink(filepath.Join(target, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "sub/file", link)
+}
+
+func TestSweepWorkDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"name-123-0-456", "name-abc-def-2-789", "name-notawork-dir", "other-123-0-456"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(dir, name), 0755))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "name-1-0-2"), []byte{}, 0644))
+
+	config := TaskConfig{FunctionName: "name", WorkDirRoot: dir}
+	assert.NoError(t, config.SweepWorkDirs())
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Equal(t, []string{"name-1-0-2", "name-notawork-dir", "other-123-0-456"}, names)
+}
+
+func TestWaitForFreeSpace(t *testing.T) {
+	mockClock := clock.NewMock()
+	gearcmdconfig.Clock = mockClock
+	defer func() {
+		gearcmdconfig.Clock = clock.New()
+	}()
+	free := uint64(10)
+	var mu sync.Mutex
+	freeSpace = func(string) (uint64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return free, nil
+	}
+	defer func() { freeSpace = diskFreeSpace }()
+
+	config := TaskConfig{MinFreeSpace: 100, FreeSpaceCheckInterval: time.Second, Halt: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		config.WaitForFreeSpace()
+		close(done)
+	}()
+	// let it check while there's too little space
+	time.Sleep(10 * time.Millisecond)
+	mockClock.Add(time.Second)
+	select {
+	case <-done:
+		t.Fatal("returned without enough free space")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	mu.Lock()
+	free = 100
+	mu.Unlock()
+	mockClock.Add(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("didn't return once there was enough free space")
+	}
+}
+
+func TestWaitForFreeSpaceHalt(t *testing.T) {
+	freeSpace = func(string) (uint64, error) { return 0, nil }
+	defer func() { freeSpace = diskFreeSpace }()
+	config := TaskConfig{MinFreeSpace: 100, Halt: make(chan struct{})}
+	close(config.Halt)
+	// returns right away instead of waiting for the check interval
+	config.WaitForFreeSpace()
+}
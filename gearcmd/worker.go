@@ -1,24 +1,27 @@
 package gearcmd
 
 import (
-	"bufio"
-	"bytes"
 	"container/ring"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
-	"math/rand"
 	"os"
 	"os/exec"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/Clever/gearcmd/argsparser"
+	"github.com/Clever/gearcmd/argspolicy"
 	"github.com/Clever/gearcmd/baseworker"
+	"github.com/Clever/gearcmd/cgroup"
 	"github.com/Clever/gearcmd/config"
+	"github.com/Clever/gearcmd/procinit"
+	"github.com/Clever/gearcmd/watchdog"
+	"github.com/Clever/gearcmd/workdata"
 	"gopkg.in/Clever/kayvee-go.v6/logger"
 )
 
@@ -27,16 +30,63 @@ import (
 type TaskConfig struct {
 	FunctionName            string
 	FunctionCmd             string
+	WorkerID                string
+	EnvMode                 string
+	EnvAllow                []string
+	Env                     []string
+	SecretsDir              string
+	Secrets                 []Secret
+	JobIDSource             JobIDSource
+	WorkerHostname          string
 	WarningLines            int
+	JobLogs                 string
+	JobLogDir               string
+	JobLogMaxFiles          int
+	JobLogMaxAge            time.Duration
+	OutputFraming           string
+	OutputEncoding          string
+	OutputBatchSize         int
+	OutputFlushInterval     time.Duration
+	MaxStdoutBytes          int64
+	MaxStderrBytes          int64
+	OutputLimitPolicy       string
+	LiveWarnings            bool
+	LiveWarningInterval     time.Duration
 	ParseArgs               bool
+	ResultFile              bool
+	ResultFileMaxSize       int64
+	WorkDirRoot             string
+	MinFreeSpace            int64
+	FreeSpaceCheckInterval  time.Duration
+	KeepWorkDirOnFailure    bool
+	FailedWorkDirRoot       string
+	FailedWorkDirMaxCount   int
+	FailedWorkDirMaxBytes   int64
+	Progress                bool
+	ProgressInterval        time.Duration
+	ArgsPolicy              *argspolicy.Policy
+	ExitCodePolicy          ExitCodePolicy
+	Rlimits                 []procinit.Rlimit
+	Cgroups                 *cgroup.Manager
+	RunAs                   *syscall.Credential
+	Sandbox                 bool
+	Watchdog                *watchdog.Watchdog
 	CmdTimeout              time.Duration
+	JobDeadline             time.Duration
 	RetryCount              int
+	RetryBackoff            time.Duration
+	RetryBackoffMultiplier  float64
+	RetryBackoffMax         time.Duration
+	RetryBackoffJitter      float64
 	Halt                    chan struct{}
 	LastResults             *ring.Ring
 	ErrorResultsBackoffRate time.Duration
 	SigtermGracePeriod      time.Duration
+	StopSignal              syscall.Signal
 	// this variable tracks how much to backoff if another failure happens
 	currentErrorResultsBackoff time.Duration
+	// the process group of the command that's running, 0 when there's none
+	runningPgid int32
 }
 
 var (
@@ -48,8 +98,10 @@ var (
 )
 
 // ProcessWithErrorBackoff calls Process and sleeps if the last N jobs returned an error
+// It also waits for enough free space in the work directory root before taking the next job.
 func (conf *TaskConfig) ProcessWithErrorBackoff(job baseworker.Job) (b []byte, returnErr error) {
 	b, returnErr = conf.Process(job)
+	defer conf.WaitForFreeSpace()
 	if conf.LastResults == nil || conf.ErrorResultsBackoffRate == 0 {
 		return b, returnErr
 	}
@@ -80,14 +132,10 @@ func (conf *TaskConfig) ProcessWithErrorBackoff(job baseworker.Job) (b []byte, r
 }
 
 // Process runs the Gearman job by running the configured task.
-// We need to implement the Task interface so we return (byte[], error)
-// though the byte[] is always nil.
+// We need to implement the Task interface so we return (byte[], error).
+// The byte[] is the contents of the result file when ResultFile is set, otherwise nil.
 func (conf *TaskConfig) Process(job baseworker.Job) (b []byte, returnErr error) {
-	jobID := getJobID(job)
-	if jobID == "" {
-		jobID = strconv.Itoa(rand.Int())
-		lg.InfoD("rand-job-id", logger.M{"msg": "no job id parsed, random assigned."})
-	}
+	jobID := conf.JobIDSource.jobID(job)
 
 	jobData := string(job.Data())
 	data := logger.M{
@@ -99,25 +147,100 @@ func (conf *TaskConfig) Process(job baseworker.Job) (b []byte, returnErr error)
 	// This wraps the actual processing to do some logging
 	lg.InfoD("START", data)
 	start := time.Now()
+	var tryInfo logger.M
+
+	// Parse and check the arguments once, a job with bad arguments fails without being retried.
+	args, err := conf.jobArgs(job, jobID)
+	var secrets []string
+	if err == nil {
+		// Secrets are read for every job so that rotated secrets are picked up without a restart.
+		if secrets, err = conf.secretsEnv(); err != nil {
+			lg.ErrorD("secrets-failure", logger.M{"function": conf.FunctionName, "job_id": jobID, "error": err.Error()})
+		}
+	}
+	if err != nil {
+		data["type"] = "gauge"
+		data["value"] = 0
+		data["success"] = false
+		data["error_message"] = err.Error()
+		conf.logFailure(data)
+		return nil, err
+	}
+
+	// The deadline bounds all the tries of the job and the waits between them.
+	var deadline time.Time
+	if conf.JobDeadline > 0 {
+		deadline = config.Clock.Now().Add(conf.JobDeadline)
+	}
 
 	for try := 0; try < conf.RetryCount+1; try++ {
 		// We create a temporary directory to be used as the work directory of the process.
 		// A new work directory is created for every retry of the process.
-		// We try to use MEOS_SANDBOX, the default will be the system temp directory.
-		tempDirPath, err := ioutil.TempDir(os.Getenv("MESOS_SANDBOX"),
+		tempDirPath, err := ioutil.TempDir(conf.workDirRoot(),
 			fmt.Sprintf("%s-%s-%d-", conf.FunctionName, jobID, try))
 		if err != nil {
 			lg.CriticalD("tempdir-failure", logger.M{"error": err.Error()})
 			return nil, err
 		}
-		defer os.RemoveAll(tempDirPath)
+		if conf.RunAs != nil {
+			if err := os.Chown(tempDirPath, int(conf.RunAs.Uid), int(conf.RunAs.Gid)); err != nil {
+				lg.CriticalD("tempdir-failure", logger.M{"error": err.Error()})
+				os.RemoveAll(tempDirPath)
+				return nil, err
+			}
+		}
 
-		// insert the job id and the work directory path into the environment
-		extraEnvVars := []string{
-			fmt.Sprintf("JOB_ID=%s", jobID),
-			fmt.Sprintf("WORK_DIR=%s", tempDirPath)}
+		// insert the secrets, the job's context and the work directory path into the environment
+		extraEnvVars := append(append([]string{}, secrets...), conf.jobEnv(job, jobID, try)...)
+		extraEnvVars = append(extraEnvVars, fmt.Sprintf("WORK_DIR=%s", tempDirPath))
+		if conf.Sandbox {
+			// the work directory is the only place a sandboxed command can write to
+			extraEnvVars = append(extraEnvVars, fmt.Sprintf("TMPDIR=%s", tempDirPath))
+		}
+		resultFilePath := filepath.Join(tempDirPath, resultFileName)
+		if conf.ResultFile {
+			extraEnvVars = append(extraEnvVars, fmt.Sprintf("RESULT_FILE=%s", resultFilePath))
+		}
+		timeout := conf.CmdTimeout
+		if !deadline.IsZero() {
+			extraEnvVars = append(extraEnvVars, fmt.Sprintf("JOB_DEADLINE=%s", deadline.UTC().Format(time.RFC3339)))
+			if remaining := deadline.Sub(config.Clock.Now()); timeout == 0 || remaining < timeout {
+				timeout = remaining
+			}
+		}
 
-		err = conf.doProcess(job, extraEnvVars, try)
+		// replace what the previous try reported with what this one does
+		for key := range tryInfo {
+			delete(data, key)
+		}
+		tryInfo = logger.M{}
+		err = conf.doProcess(job, jobID, args, extraEnvVars, tempDirPath, try, timeout, tryInfo)
+		var retryable bool
+		retryable, err = conf.classifyExit(job, err, tryInfo)
+		for key, value := range tryInfo {
+			data[key] = value
+		}
+		var result []byte
+		resultFailed := false
+		if err == nil && conf.ResultFile {
+			if result, err = readResultFile(resultFilePath, conf.ResultFileMaxSize); err != nil {
+				// the command itself succeeded, so running it again won't fix its result
+				lg.ErrorD("result-file-failure", logger.M{
+					"function": conf.FunctionName,
+					"job_id":   jobID,
+					"error":    err.Error(),
+				})
+				resultFailed = true
+			}
+		}
+		if err != nil && conf.KeepWorkDirOnFailure {
+			if keptPath := conf.keepWorkDir(tempDirPath); keptPath != "" {
+				tryInfo["kept_work_dir"] = keptPath
+				data["kept_work_dir"] = keptPath
+			}
+		} else {
+			os.RemoveAll(tempDirPath)
+		}
 		end := time.Now()
 		data["type"] = "gauge"
 
@@ -134,13 +257,19 @@ func (conf *TaskConfig) Process(job baseworker.Job) (b []byte, returnErr error)
 			lg.InfoD("END", data)
 			// Hopefully none of our jobs last long enough for a uint64...
 			// Note that we cannot use lg.GaugeIntD because duration is uint64
-			lg.InfoD("duration", logger.M{
+			duration := logger.M{
 				"value":    uint64(end.Sub(start).Seconds() * 1000),
 				"type":     "gauge",
 				"function": conf.FunctionName,
 				"job_id":   jobID,
-				"job_data": jobData})
-			return nil, nil
+				"job_data": jobData}
+			for _, key := range rusageKeys {
+				if value, ok := tryInfo[key]; ok {
+					duration[key] = value
+				}
+			}
+			lg.InfoD("duration", duration)
+			return result, nil
 		}
 
 		data["value"] = 0
@@ -148,15 +277,91 @@ func (conf *TaskConfig) Process(job baseworker.Job) (b []byte, returnErr error)
 		data["error_message"] = err.Error()
 		returnErr = err
 
+		if resultFailed || !retryable {
+			break
+		}
 		if try != conf.RetryCount {
+			backoff := conf.retryBackoff(try)
+			if !deadline.IsZero() && !config.Clock.Now().Add(backoff).Before(deadline) {
+				// the next try wouldn't start before the deadline
+				data["deadline_exceeded"] = true
+				break
+			}
+			if backoff > 0 {
+				tryInfo["retry_backoff"] = backoff.String()
+				data["retry_backoff"] = backoff.String()
+			}
 			lg.ErrorD("RETRY", data)
+			if !conf.waitToRetry(backoff) {
+				break
+			}
 		}
 	}
 
+	conf.logFailure(data)
+	return nil, returnErr
+}
+
+// logFailure logs the events for a job that failed. data holds the job's END event.
+func (conf *TaskConfig) logFailure(data logger.M) {
 	lg.InfoD("FAILURE", logger.M{"type": "counter", "function": conf.FunctionName})
 	legacyLg.InfoD("failure", logger.M{"type": "counter", "function": conf.FunctionName})
 	lg.ErrorD("END", data)
-	return nil, returnErr
+}
+
+// jobArgs returns the arguments to run the command with, checked against the ArgsPolicy.
+func (conf *TaskConfig) jobArgs(job baseworker.Job, jobID string) ([]string, error) {
+	var args []string
+	if conf.ParseArgs {
+		var err error
+		args, err = argsparser.ParseArgs(string(job.Data()))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse args: %s", err.Error())
+		}
+	} else {
+		args = []string{string(job.Data())}
+	}
+	if conf.ArgsPolicy != nil {
+		if err := conf.ArgsPolicy.Check(args); err != nil {
+			lg.WarnD("args-rejected", logger.M{
+				"function": conf.FunctionName,
+				"job_id":   jobID,
+				"error":    err.Error(),
+			})
+			job.SendWarning([]byte(fmt.Sprintf("job arguments rejected: %s\n", err.Error())))
+			return nil, fmt.Errorf("job arguments rejected: %s", err.Error())
+		}
+	}
+	return args, nil
+}
+
+// resultFileName is the name of the file, inside the work directory, that the command can
+// write its result to when TaskConfig.ResultFile is set.
+const resultFileName = "result"
+
+// readResultFile returns the contents of the result file, or nil if the command didn't write one.
+// It fails if the file is larger than maxSize bytes, unless maxSize is 0.
+func readResultFile(path string, maxSize int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read result file: %s", err.Error())
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if maxSize > 0 {
+		// read one byte more than allowed to tell whether the file is too large
+		reader = io.LimitReader(file, maxSize+1)
+	}
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read result file: %s", err.Error())
+	}
+	if maxSize > 0 && int64(len(result)) > maxSize {
+		return nil, fmt.Errorf("result file is larger than %d bytes", maxSize)
+	}
+	return result, nil
 }
 
 // getJobID returns the jobId from the job handle
@@ -165,7 +370,10 @@ func getJobID(job baseworker.Job) string {
 	return splits[len(splits)-1]
 }
 
-func (conf *TaskConfig) doProcess(job baseworker.Job, envVars []string, tryCount int) error {
+// doProcess runs the command once with workDir as its WORK_DIR, killing it after timeout unless
+// that's 0. Anything about the run worth adding to the job's END and RETRY events is added to info.
+func (conf *TaskConfig) doProcess(job baseworker.Job, jobID string, args []string, envVars []string, workDir string,
+	tryCount int, timeout time.Duration, info logger.M) error {
 	defer func() {
 		// If we panicked then set the panic message as a warning. Gearman-go will
 		// handle marking this job as failed.
@@ -181,6 +389,8 @@ func (conf *TaskConfig) doProcess(job baseworker.Job, envVars []string, tryCount
 		shutdownTicker <- 1
 	}()
 
+	progress := newProgressReporter(job, conf.ProgressInterval)
+
 	// every minute we will output a heartbeat kayvee log for the job.
 	tickUnit := time.Minute
 	ticker := time.NewTicker(tickUnit)
@@ -194,69 +404,195 @@ func (conf *TaskConfig) doProcess(job baseworker.Job, envVars []string, tryCount
 				return
 			case <-ticker.C:
 				units++
-				lg.GaugeIntD("heartbeat", units, logger.M{
+				data := logger.M{
 					"try_number": tryCount,
 					"function":   job.Fn(),
-					"job_id":     getJobID(job),
+					"job_id":     jobID,
 					"unit":       tickUnit.String(),
-				})
+				}
+				if numerator, denominator, ok := progress.current(); ok {
+					data["progress_numerator"] = numerator
+					data["progress_denominator"] = denominator
+				}
+				lg.GaugeIntD("heartbeat", units, data)
 			}
 		}
 	}()
 
-	var args []string
 	var err error
-	if conf.ParseArgs {
-		args, err = argsparser.ParseArgs(string(job.Data()))
-		if err != nil {
-			return fmt.Errorf("Failed to parse args: %s", err.Error())
-		}
-	} else {
-		args = []string{string(job.Data())}
-	}
 	cmd := exec.Command(conf.FunctionCmd, args...)
 
 	// insert provided env vars into the job
-	cmd.Env = append(os.Environ(), envVars...)
+	cmd.Env = append(conf.commandEnv(), envVars...)
+
+	// give the process a pipe to report its progress through
+	var progressReader, progressWriter *os.File
+	if conf.Progress {
+		if progressReader, progressWriter, err = os.Pipe(); err != nil {
+			return fmt.Errorf("unable to create progress pipe: %s", err.Error())
+		}
+		defer progressReader.Close()
+		cmd.ExtraFiles = []*os.File{progressWriter}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("PROGRESS_FD=%d", progressFD))
+	}
 
 	// create new pgid for this process so we can later kill all subprocess launched by it
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: conf.RunAs}
+	setParentDeathSignal(cmd.SysProcAttr)
+	spec := procinit.Spec{Rlimits: conf.Rlimits}
+	var group *cgroup.Group
+	if conf.Cgroups != nil {
+		if group, err = conf.Cgroups.NewGroup(fmt.Sprintf("%s-%s-%d", conf.FunctionName, jobID, tryCount)); err != nil {
+			return err
+		}
+		defer func() {
+			if err := group.Remove(); err != nil {
+				lg.ErrorD("cgroup-remove-failure", logger.M{"job_id": jobID, "error": err.Error()})
+			}
+		}()
+		spec.Cgroup = group.Path()
+	}
+	if conf.Sandbox {
+		spec.SandboxWorkDir = workDir
+	}
+	if err := procinit.Wrap(cmd, spec); err != nil {
+		return err
+	}
 
-	// Write the stdout and stderr of the process to both this process' stdout and stderr
-	// and also write it to a byte buffer so that we can return it with the Gearman job
-	// data as necessary.
-	var stderrbuf bytes.Buffer
-	cmd.Stderr = io.MultiWriter(os.Stderr, &stderrbuf)
-	defer sendStderrWarnings(&stderrbuf, job, conf.WarningLines)
+	// Write the stdout and stderr of the process to both the job logs (by default this process'
+	// stdout and stderr) and also send them to the Gearman job: stdout as data, stderr as warnings.
+	stdoutLog, stderrLog, err := conf.jobLogWriters(jobID, tryCount)
+	if err != nil {
+		return fmt.Errorf("unable to create job logs: %s", err.Error())
+	}
+	defer stdoutLog.Close()
+	defer stderrLog.Close()
+	stderrCapture := newStderrCapture(job, conf.WarningLines, conf.LiveWarnings, conf.LiveWarningInterval)
+	defer stderrCapture.Close()
+
+	var stdoutData *dataWriter
+	var stdoutEncoder io.WriteCloser
+	var stdout io.Writer = stdoutLog
+	if !conf.ResultFile {
+		// when there's a result file the job's result comes from it, so stdout only goes to the logs
+		stdoutData = newDataWriter(job, conf.OutputFraming, conf.OutputBatchSize, conf.OutputFlushInterval)
+		if stdoutEncoder, err = workdata.NewWriter(stdoutData, conf.OutputEncoding); err != nil {
+			return err
+		}
+		stdout = io.MultiWriter(stdoutLog, stdoutEncoder)
+	}
 
-	stdoutReader, stdoutWriter := io.Pipe()
-	cmd.Stdout = io.MultiWriter(os.Stdout, stdoutWriter)
+	// cap how much of the output we pass on
+	stdoutLimit := &limitWriter{w: stdout, stream: "stdout", limit: conf.MaxStdoutBytes}
+	stderrLimit := &limitWriter{w: io.MultiWriter(stderrLog, stderrCapture), stream: "stderr", limit: conf.MaxStderrBytes}
+	outputLimits := []*limitWriter{stdoutLimit, stderrLimit}
+	for _, l := range outputLimits {
+		l := l
+		l.onExceeded = func() { conf.outputLimitExceeded(job, jobID, cmd, l) }
+	}
+	defer func() {
+		for _, l := range outputLimits {
+			if l.wasExceeded() {
+				info[l.stream+"_limit_exceeded"] = true
+				info["output_limit_policy"] = conf.outputLimitPolicy()
+			}
+		}
+	}()
+
+	// The output goes through pipes of our own rather than ones exec.Cmd copies from, so Wait
+	// returns once the command exits even if processes it left behind still hold them.
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("unable to create stdout pipe: %s", err.Error())
+	}
+	defer stdoutReader.Close()
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutWriter.Close()
+		return fmt.Errorf("unable to create stderr pipe: %s", err.Error())
+	}
+	defer stderrReader.Close()
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
 
 	done := make(chan error)
+	// Whether processes left behind by the command were killed, set before done is closed.
+	var killedStragglers bool
 	// Track when the job has started so that we don't try and sigterm a nil process
 	started := make(chan struct{})
 	go func() {
 		defer close(done)
 
-		finishedProcessingStdout := make(chan error)
-		go func() {
-			finishedProcessingStdout <- streamToGearman(stdoutReader, job)
-		}()
-
-		if err := cmd.Start(); err != nil {
+		finishedProcessingProgress := make(chan error, 1)
+		err := cmd.Start()
+		// the child has its own copies, close ours so the readers see EOF once it's done
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		if err != nil {
+			if progressWriter != nil {
+				progressWriter.Close()
+			}
 			done <- err
 			return
 		}
+		if conf.Watchdog != nil {
+			if err := conf.Watchdog.Watch(cmd.Process.Pid); err != nil {
+				lg.ErrorD("watchdog-failure", logger.M{"job_id": jobID, "error": err.Error()})
+			}
+		}
+		atomic.StoreInt32(&conf.runningPgid, int32(cmd.Process.Pid))
+		copied := make(chan error, 2)
+		go func() {
+			_, err := io.Copy(stdoutLimit, stdoutReader)
+			copied <- err
+		}()
+		go func() {
+			_, err := io.Copy(stderrLimit, stderrReader)
+			copied <- err
+		}()
+		if progressWriter != nil {
+			// the child has its own copy, close ours so the reader sees EOF when the child exits
+			progressWriter.Close()
+			go func() {
+				finishedProcessingProgress <- progress.consume(progressReader)
+			}()
+		} else {
+			finishedProcessingProgress <- nil
+		}
 		close(started)
-		// Save the cmdErr. We want to process stdout and stderr before we return it
+		// Save the cmdErr. Once whatever the command left behind is gone, all of stdout and
+		// stderr has been written, so we can send whatever is still buffered before we return it.
 		cmdErr := cmd.Wait()
-		stdoutWriter.Close()
+		atomic.StoreInt32(&conf.runningPgid, 0)
+		killedStragglers = conf.killStragglers(jobID, cmd.Process.Pid)
+		if conf.Watchdog != nil {
+			if err := conf.Watchdog.Unwatch(cmd.Process.Pid); err != nil {
+				lg.ErrorD("watchdog-failure", logger.M{"job_id": jobID, "error": err.Error()})
+			}
+		}
+		for i := 0; i < 2; i++ {
+			if err := <-copied; err != nil && cmdErr == nil {
+				cmdErr = err
+			}
+		}
+		if stdoutData != nil {
+			if err := stdoutEncoder.Close(); err != nil && cmdErr == nil {
+				cmdErr = fmt.Errorf("unable to encode stdout: %s", err.Error())
+			}
+			stdoutData.Close()
+		}
 
-		stdoutErr := <-finishedProcessingStdout
+		if err := <-finishedProcessingProgress; err != nil {
+			lg.WarnD("progress-read-failure", logger.M{"function": conf.FunctionName, "error": err.Error()})
+		}
+		for _, l := range outputLimits {
+			if l.wasExceeded() && conf.outputLimitPolicy() == LimitPolicyFail {
+				cmdErr = fmt.Errorf("%s exceeded the limit of %d bytes", l.stream, l.limit)
+				break
+			}
+		}
 		if cmdErr != nil {
 			done <- cmdErr
-		} else if stdoutErr != nil {
-			done <- stdoutErr
 		}
 	}()
 	<-started
@@ -268,19 +604,22 @@ func (conf *TaskConfig) doProcess(job baseworker.Job, envVars []string, tryCount
 			timedOutCount = 1
 		}
 		lg.CounterD("worker-timed-out", timedOutCount, logger.M{
-			"timeout":  conf.CmdTimeout,
+			"timeout":  timeout,
 			"function": conf.FunctionName,
 		})
 	}()
 
 	// No timeout
-	if conf.CmdTimeout == 0 {
+	if timeout == 0 {
 		select {
 		case err := <-done:
 			// Will be nil if the channel was closed without any errors
-			return err
+			if killedStragglers {
+				info["stragglers_killed"] = true
+			}
+			return conf.commandExited(job, cmd, group, err, info)
 		case <-conf.Halt:
-			if err := stopProcess(cmd.Process, conf.SigtermGracePeriod); err != nil {
+			if err := stopProcess(cmd.Process, conf.stopSignal(), conf.SigtermGracePeriod); err != nil {
 				return fmt.Errorf("error stopping process: %s", err)
 			}
 			return fmt.Errorf("killed process due to sigterm")
@@ -289,29 +628,59 @@ func (conf *TaskConfig) doProcess(job baseworker.Job, envVars []string, tryCount
 	select {
 	case err := <-done:
 		// Will be nil if the channel was closed without any errors
-		return err
+		if killedStragglers {
+			info["stragglers_killed"] = true
+		}
+		return conf.commandExited(job, cmd, group, err, info)
 	case <-conf.Halt:
-		if err := stopProcess(cmd.Process, conf.CmdTimeout); err != nil {
+		if err := stopProcess(cmd.Process, conf.stopSignal(), timeout); err != nil {
 			return fmt.Errorf("error stopping process: %s", err)
 		}
 		return nil
-	case <-time.After(conf.CmdTimeout):
+	case <-time.After(timeout):
 		timedOut = true
-		if err := stopProcess(cmd.Process, 0); err != nil {
-			return fmt.Errorf("error timing out process after %s: %s", conf.CmdTimeout.String(), err)
+		if err := stopProcess(cmd.Process, conf.stopSignal(), 0); err != nil {
+			return fmt.Errorf("error timing out process after %s: %s", timeout.String(), err)
 		}
-		return fmt.Errorf("process timed out after %s", conf.CmdTimeout.String())
+		return fmt.Errorf("process timed out after %s", timeout.String())
+	}
+}
+
+// outputLimitPolicy returns the OutputLimitPolicy, defaulting to LimitPolicyTruncate.
+func (conf *TaskConfig) outputLimitPolicy() string {
+	if conf.OutputLimitPolicy == "" {
+		return LimitPolicyTruncate
 	}
+	return conf.OutputLimitPolicy
 }
 
-// stopProcess kills a given process. It's second argument is a grace period.
+// outputLimitExceeded applies the OutputLimitPolicy once the command writes more than l allows.
+func (conf *TaskConfig) outputLimitExceeded(job baseworker.Job, jobID string, cmd *exec.Cmd, l *limitWriter) {
+	lg.WarnD("output-limit-exceeded", logger.M{
+		"function": conf.FunctionName,
+		"job_id":   jobID,
+		"stream":   l.stream,
+		"limit":    l.limit,
+		"policy":   conf.outputLimitPolicy(),
+	})
+	if conf.outputLimitPolicy() == LimitPolicyFail {
+		// kill the whole process group, Wait returns once it's gone
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			lg.ErrorD("unable-to-kill", logger.M{"pid": cmd.Process.Pid, "error": err.Error()})
+		}
+		return
+	}
+	job.SendWarning([]byte(fmt.Sprintf("%s exceeded the limit of %d bytes, the rest was dropped\n", l.stream, l.limit)))
+}
+
+// stopProcess sends signal to a given process. It's third argument is a grace period.
 // If, after the grace period, the process hasn't exited, SIGKILL will be sent.
 // It also calls os.Exit, since we currently rely on cutting off the connection
 // with gearmand to trigger reassignment of work to another worker.
-func stopProcess(p *os.Process, gracePeriod time.Duration) error {
-	lg.InfoD("stopping-process", logger.M{"pid": p.Pid, "grace_period": gracePeriod})
-	if err := p.Signal(os.Signal(syscall.SIGTERM)); err != nil {
-		return fmt.Errorf("unable to send SIGTERM, error: %s", err)
+func stopProcess(p *os.Process, signal syscall.Signal, gracePeriod time.Duration) error {
+	lg.InfoD("stopping-process", logger.M{"pid": p.Pid, "signal": signal.String(), "grace_period": gracePeriod})
+	if err := p.Signal(signal); err != nil {
+		return fmt.Errorf("unable to send %s, error: %s", signal, err)
 	}
 	timer := time.AfterFunc(gracePeriod, func() {
 		// kill entire group of process spawned by our cmd.Process
@@ -357,43 +726,3 @@ func stopProcess(p *os.Process, gracePeriod time.Duration) error {
 	}
 	return nil
 }
-
-// This function streams the reader to the Gearman job (through job.SendData())
-func streamToGearman(reader io.Reader, job baseworker.Job) error {
-	buffer := make([]byte, 1024)
-	for {
-		n, err := reader.Read(buffer)
-		// Process the data before processing the error (as per the io.Reader docs)
-		if n > 0 {
-			job.SendData(buffer[:n])
-		}
-		if err == io.EOF {
-			return nil
-		} else if err != nil {
-			return err
-		}
-	}
-}
-
-// sendStderrWarnings sends the last X lines in the stderr output and to the job's warnings
-// field
-func sendStderrWarnings(buffer io.Reader, job baseworker.Job, warningLines int) error {
-	scanner := bufio.NewScanner(buffer)
-	// Create a circular buffer for the last X lines
-	lastStderrLines := ring.New(warningLines)
-	for scanner.Scan() {
-		lastStderrLines = lastStderrLines.Next()
-		lastStderrLines.Value = scanner.Bytes()
-	}
-	// Walk forward through the buffer to get all the last X entries. Note that we call next first
-	// so that we start at the oldest entry.
-	stderrbuf := []byte{}
-	for i := 0; i < lastStderrLines.Len(); i++ {
-		if lastStderrLines = lastStderrLines.Next(); lastStderrLines.Value != nil {
-			stderrbuf = append(stderrbuf, lastStderrLines.Value.([]byte)...)
-			stderrbuf = append(stderrbuf, '\n')
-		}
-	}
-	job.SendWarning(stderrbuf)
-	return scanner.Err()
-}
@@ -11,12 +11,23 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Clever/gearcmd/argspolicy"
 	mock "github.com/Clever/gearcmd/baseworker/mock"
 	gearcmdconfig "github.com/Clever/gearcmd/config"
+	"github.com/Clever/gearcmd/procinit"
+	"github.com/Clever/gearcmd/watchdog"
+	"github.com/Clever/gearcmd/workdata"
 	"github.com/facebookgo/clock"
 	"github.com/stretchr/testify/assert"
 )
 
+// TestMain lets the test binary set up the commands it runs, like the gearcmd binary does.
+func TestMain(m *testing.M) {
+	procinit.Init()
+	watchdog.Init()
+	os.Exit(m.Run())
+}
+
 // Helper function to get the response for a job that should be successful
 func getSuccessResponse(payload string, cmd string, t *testing.T) string {
 	config := TaskConfig{FunctionName: "name", FunctionCmd: cmd, WarningLines: 5, ParseArgs: true}
@@ -145,6 +156,30 @@ func TestStderrCapturedWarningsOnFailedJobs(t *testing.T) {
 	assert.Equal(t, warnings, [][]byte{[]byte("stderr7\nstderr8\n")})
 }
 
+func TestArgsPolicyRejectsWithoutRetry(t *testing.T) {
+	file, err := ioutil.TempFile("", "temp")
+	assert.NoError(t, err)
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer file.Close()
+	mockJob := mock.CreateMockJob("--output " + filename)
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/succeedOnFifthRun.sh",
+		ParseArgs:    true,
+		RetryCount:   4,
+	}
+	config.ArgsPolicy, err = argspolicy.New(nil, []string{".*"}, 0)
+	assert.NoError(t, err)
+	_, err = config.Process(mockJob)
+	assert.EqualError(t, err, `job arguments rejected: flag "--output" is not allowed`)
+	assert.Equal(t, [][]byte{[]byte("job arguments rejected: flag \"--output\" is not allowed\n")}, mockJob.Warnings())
+	// the script never ran, so it never recorded a run in the file
+	contents, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Empty(t, contents)
+}
+
 func TestMockJobName(t *testing.T) {
 	mockJob := &mock.Job{GearmanHandle: "H:lap:123"}
 	assert.Equal(t, "123", getJobID(mockJob))
@@ -187,6 +222,219 @@ func TestEnvJobIDInsertion(t *testing.T) {
 	assert.Contains(t, response, "WORK_DIR=/tmp/name-123-0")
 }
 
+func TestEnvJobContext(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	mockJob.GearmanHandle = "H:lap:123"
+	mockJob.ID = "unique"
+	config := TaskConfig{
+		FunctionName:   "name",
+		FunctionCmd:    "testscripts/output_env.sh",
+		RetryCount:     2,
+		WorkerID:       "host-42",
+		WorkerHostname: "host",
+	}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	env := strings.Split(string(mockJob.OutData()), "\n")
+	for _, expected := range []string{"GEARMAN_FUNCTION=name", "GEARMAN_HANDLE=H:lap:123", "GEARMAN_UNIQUE_ID=unique",
+		"TRY_NUMBER=1", "MAX_TRIES=3", "WORKER_ID=host-42", "WORKER_HOSTNAME=host"} {
+		assert.Contains(t, env, expected)
+	}
+}
+
+func runWithEnvMode(t *testing.T, mode string, allow []string) []string {
+	os.Setenv("GEARCMD_TEST_SECRET", "secret")
+	os.Setenv("GEARCMD_TEST_ALLOWED", "allowed")
+	defer os.Unsetenv("GEARCMD_TEST_SECRET")
+	defer os.Unsetenv("GEARCMD_TEST_ALLOWED")
+	config := TaskConfig{
+		FunctionName: "name",
+		FunctionCmd:  "testscripts/output_env.sh",
+		EnvMode:      mode,
+		EnvAllow:     allow,
+		Env:          []string{"EXTRA=value", "JOB_ID=overridden"},
+	}
+	return strings.Split(getSuccessResponseWithConfig("", config, t), "\n")
+}
+
+func TestEnvModes(t *testing.T) {
+	env := runWithEnvMode(t, EnvInherit, nil)
+	assert.Contains(t, env, "GEARCMD_TEST_SECRET=secret")
+	assert.Contains(t, env, "EXTRA=value")
+	// the job's own variables win
+	assert.Contains(t, env, "JOB_ID=123")
+	assert.NotContains(t, env, "JOB_ID=overridden")
+
+	env = runWithEnvMode(t, EnvAllowlist, []string{"PATH", "GEARCMD_TEST_A*"})
+	assert.Contains(t, env, "GEARCMD_TEST_ALLOWED=allowed")
+	assert.Contains(t, env, "PATH="+os.Getenv("PATH"))
+	assert.NotContains(t, env, "GEARCMD_TEST_SECRET=secret")
+	assert.Contains(t, env, "EXTRA=value")
+
+	env = runWithEnvMode(t, EnvClean, nil)
+	assert.NotContains(t, env, "GEARCMD_TEST_ALLOWED=allowed")
+	assert.NotContains(t, env, "PATH="+os.Getenv("PATH"))
+	assert.Contains(t, env, "EXTRA=value")
+	assert.Contains(t, env, "JOB_ID=123")
+}
+
+func TestValidateEnv(t *testing.T) {
+	assert.NoError(t, ValidateEnv([]string{"KEY=VALUE", "EMPTY="}))
+	assert.Error(t, ValidateEnv([]string{"KEY"}))
+	assert.Error(t, ValidateEnv([]string{"=VALUE"}))
+	assert.Error(t, ValidateEnvMode("none"))
+}
+
+func TestResultFileReturnedAsResult(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/writeResultFile.sh", ResultFile: true}
+	response, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Equal(t, "the result", string(response))
+	// stdout isn't sent to the client when using a result file
+	assert.Empty(t, mockJob.OutData())
+}
+
+func TestResultFileNotWritten(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/success.sh", ResultFile: true}
+	response, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Nil(t, response)
+}
+
+func TestProgressSentAsStatus(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/reportProgress.sh", Progress: true}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, mockJob.Numerator)
+	assert.Equal(t, 3, mockJob.Denominator)
+	assert.Equal(t, "done\n", string(mockJob.OutData()))
+}
+
+func TestResultFileTooLarge(t *testing.T) {
+	file, err := ioutil.TempFile("", "temp")
+	assert.NoError(t, err)
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer file.Close()
+	mockJob := mock.CreateMockJob(filename)
+	config := TaskConfig{
+		FunctionName:      "name",
+		FunctionCmd:       "testscripts/countRunsAndWriteResult.sh",
+		ParseArgs:         true,
+		RetryCount:        2,
+		ResultFile:        true,
+		ResultFileMaxSize: 5,
+	}
+	response, err := config.Process(mockJob)
+	assert.Nil(t, response)
+	assert.EqualError(t, err, "result file is larger than 5 bytes")
+	// a result that can't be read fails the job without running the command again
+	contents, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", string(contents))
+}
+
+func runWithExitCodePolicy(t *testing.T, exitCode string) (string, error) {
+	file, err := ioutil.TempFile("", "temp")
+	assert.NoError(t, err)
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer file.Close()
+	mockJob := mock.CreateMockJob(filename + " " + exitCode)
+	policy, err := ParseExitCodePolicy("75=retry,64-70=fail,3=success")
+	assert.NoError(t, err)
+	config := TaskConfig{
+		FunctionName:   "name",
+		FunctionCmd:    "testscripts/countRunsAndExit.sh",
+		ParseArgs:      true,
+		RetryCount:     2,
+		ExitCodePolicy: policy,
+	}
+	_, err = config.Process(mockJob)
+	contents, readErr := ioutil.ReadFile(filename)
+	assert.NoError(t, readErr)
+	return string(contents), err
+}
+
+func TestExitCodePolicy(t *testing.T) {
+	runs, err := runWithExitCodePolicy(t, "75")
+	assert.EqualError(t, err, "exit status 75")
+	assert.Equal(t, "3\n", runs)
+
+	runs, err = runWithExitCodePolicy(t, "65")
+	assert.EqualError(t, err, "exit status 65")
+	assert.Equal(t, "1\n", runs)
+
+	runs, err = runWithExitCodePolicy(t, "3")
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n", runs)
+
+	// exit codes without a rule are retried
+	runs, err = runWithExitCodePolicy(t, "2")
+	assert.EqualError(t, err, "exit status 2")
+	assert.Equal(t, "3\n", runs)
+}
+
+func TestExitCodeTreatedAsSuccessWarns(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	policy, err := ParseExitCodePolicy("2=success")
+	assert.NoError(t, err)
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/nonZeroExit.sh", ExitCodePolicy: policy}
+	_, err = config.Process(mockJob)
+	assert.NoError(t, err)
+	warnings := mockJob.Warnings()
+	assert.Equal(t, "command exited with 2, which is treated as success\n", string(warnings[len(warnings)-1]))
+}
+
+func TestLineFramedOutput(t *testing.T) {
+	mockJob := newPacketRecorder()
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/printLines.sh", OutputFraming: FramingLine}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line1\n", "line2\n", "line3"}, mockJob.packets)
+}
+
+func TestOutputLimitTruncates(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/logStdoutAndStderr.sh", MaxStdoutBytes: 10, WarningLines: 5}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	assert.Equal(t, "stdout1\nst", string(mockJob.OutData()))
+	assert.Equal(t, [][]byte{
+		[]byte("stdout exceeded the limit of 10 bytes, the rest was dropped\n"),
+		[]byte("stderr1\nstderr2\n"),
+	}, mockJob.Warnings())
+}
+
+func TestOutputLimitFails(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{
+		FunctionName:      "name",
+		FunctionCmd:       "testscripts/printForever.sh",
+		MaxStdoutBytes:    1000,
+		OutputLimitPolicy: LimitPolicyFail,
+	}
+	_, err := config.Process(mockJob)
+	assert.EqualError(t, err, "stdout exceeded the limit of 1000 bytes")
+	assert.Equal(t, 1000, len(mockJob.OutData()))
+}
+
+func TestGzipEncodedOutput(t *testing.T) {
+	mockJob := mock.CreateMockJob("IgnorePayload")
+	config := TaskConfig{FunctionName: "name", FunctionCmd: "testscripts/printCSV.sh", OutputEncoding: workdata.EncodingGzip}
+	_, err := config.Process(mockJob)
+	assert.NoError(t, err)
+	output, err := workdata.Decode(mockJob.OutData())
+	assert.NoError(t, err)
+	lines := strings.Split(string(output), "\n")
+	assert.Equal(t, 1002, len(lines))
+	assert.Equal(t, "1000,name1000", lines[1000])
+	assert.True(t, len(mockJob.OutData()) < len(output))
+}
+
 func TestHaltGraceful(t *testing.T) {
 	mockJob := mock.CreateMockJob("IgnorePayload")
 	haltChan := make(chan struct{})
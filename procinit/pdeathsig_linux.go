@@ -0,0 +1,27 @@
+package procinit
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// prSetPdeathsig isn't in the syscall package.
+const prSetPdeathsig = 1
+
+func parentDeathSignal(attr *syscall.SysProcAttr) int {
+	return int(attr.Pdeathsig)
+}
+
+// setParentDeathSignal sets the signal the process gets when its parent exits, since changing
+// credentials clears it, then makes sure parent hasn't exited already.
+func setParentDeathSignal(signal, parent int) error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetPdeathsig, uintptr(signal), 0); errno != 0 {
+		return fmt.Errorf("unable to set the parent death signal: %s", errno.Error())
+	}
+	// the parent of a process in a new PID namespace is outside of it, so its ID is 0
+	if ppid := os.Getppid(); ppid != 0 && ppid != parent {
+		return fmt.Errorf("gearcmd exited")
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package procinit
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapKeepsParentDeathSignal(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	assert.NoError(t, Wrap(cmd, Spec{Rlimits: []Rlimit{{"nofile", 64}}}))
+	var spec Spec
+	for _, env := range cmd.Env {
+		if strings.HasPrefix(env, specEnvVar+"=") {
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(env, specEnvVar+"=")), &spec))
+		}
+	}
+	assert.Equal(t, int(syscall.SIGKILL), spec.ParentDeathSignal)
+	assert.Equal(t, os.Getpid(), spec.Parent)
+	assert.NoError(t, cmd.Run())
+}
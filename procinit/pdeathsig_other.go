@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package procinit
+
+import "syscall"
+
+func parentDeathSignal(attr *syscall.SysProcAttr) int {
+	return 0
+}
+
+func setParentDeathSignal(signal, parent int) error {
+	return nil
+}
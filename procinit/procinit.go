@@ -0,0 +1,256 @@
+// Package procinit sets up the process a job's command runs in, for the things that have to
+// happen after fork and before exec and that Go can't do through os/exec.
+//
+// The command is started as a copy of the gearcmd binary itself, with what to set up in an
+// environment variable. Init, which the binary has to call before anything else in main,
+// notices the variable, sets the process up and then execs the command in its place, so the
+// command keeps the pid, process group, stdio and extra files it was started with.
+package procinit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// specEnvVar holds the JSON encoded Spec of the process being set up.
+const specEnvVar = "GEARCMD_PROCINIT"
+
+// ExitCode is what the process exits with if it can't be set up. The reason is written to
+// its stderr.
+const ExitCode = 126
+
+// Rlimit is a resource limit. Value is in seconds for cpu and bytes for as, core and fsize.
+type Rlimit struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// Spec is what to set up before the command runs.
+type Spec struct {
+	Rlimits []Rlimit `json:"rlimits,omitempty"`
+	// Cgroup is the directory of the cgroup v2 the process joins.
+	Cgroup string `json:"cgroup,omitempty"`
+	// SandboxWorkDir is the only writable directory of a process sandboxed in its own mount,
+	// PID, network and IPC namespaces. The process isn't sandboxed if it's empty.
+	SandboxWorkDir string `json:"sandbox_work_dir,omitempty"`
+	// Credential is who the command runs as, once the process is set up.
+	Credential *Credential `json:"credential,omitempty"`
+	// ParentDeathSignal is sent to the process when Parent exits. It's set again once the
+	// process is set up, because changing its credential clears it.
+	ParentDeathSignal int `json:"parent_death_signal,omitempty"`
+	Parent            int `json:"parent,omitempty"`
+}
+
+// Credential is a user, group and supplementary groups.
+type Credential struct {
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups"`
+}
+
+func (s Spec) empty() bool {
+	return len(s.Rlimits) == 0 && s.Cgroup == "" && s.SandboxWorkDir == "" && s.Credential == nil
+}
+
+// ParseRlimits parses a comma separated list of <name>=<value> limits, e.g.
+// "as=1073741824,cpu=60,nofile=1024". See Rlimit for the units.
+func ParseRlimits(limits string) ([]Rlimit, error) {
+	var rlimits []Rlimit
+	if strings.TrimSpace(limits) == "" {
+		return rlimits, nil
+	}
+	for _, limit := range strings.Split(limits, ",") {
+		parts := strings.SplitN(strings.TrimSpace(limit), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("resource limit %q isn't of the form <name>=<value>", limit)
+		}
+		if _, ok := rlimitResources[parts[0]]; !ok {
+			return nil, fmt.Errorf("unknown resource limit %q, must be one of %s", parts[0], rlimitNames())
+		}
+		value, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("resource limit %q must be a number", limit)
+		}
+		rlimits = append(rlimits, Rlimit{Name: parts[0], Value: value})
+	}
+	return rlimits, nil
+}
+
+func rlimitNames() string {
+	var names []string
+	for name := range rlimitResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Wrap changes cmd to set up its process according to spec before running. It must be called
+// once cmd.Env and cmd.SysProcAttr are final. Nothing is changed if there's nothing to set up.
+// A credential in cmd.SysProcAttr is only applied once the process is set up, because
+// setting it up may need gearcmd's privileges, and a parent death signal is kept after that.
+func Wrap(cmd *exec.Cmd, spec Spec) error {
+	if spec.empty() {
+		return nil
+	}
+	var attr syscall.SysProcAttr
+	if cmd.SysProcAttr != nil {
+		attr = *cmd.SysProcAttr
+	}
+	if attr.Credential != nil {
+		spec.Credential = &Credential{UID: attr.Credential.Uid, GID: attr.Credential.Gid, Groups: attr.Credential.Groups}
+		attr.Credential = nil
+	}
+	if spec.SandboxWorkDir != "" {
+		sandboxProcAttr(&attr)
+	}
+	if signal := parentDeathSignal(&attr); signal != 0 {
+		spec.ParentDeathSignal = signal
+		spec.Parent = os.Getpid()
+	}
+	cmd.SysProcAttr = &attr
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to find the gearcmd executable: %s", err.Error())
+	}
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, fmt.Sprintf("%s=%s", specEnvVar, encoded))
+	// Init gets the command's path as its argv[0], followed by the command's own argv
+	cmd.Args = append([]string{cmd.Path}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// Init sets up the process and execs the command if the process was started by a command
+// passed to Wrap, and returns right away otherwise. It never returns in the first case.
+func Init() {
+	encoded, ok := os.LookupEnv(specEnvVar)
+	if !ok {
+		return
+	}
+	os.Unsetenv(specEnvVar)
+	if err := run(encoded); err != nil {
+		fmt.Fprintf(os.Stderr, "gearcmd: %s\n", err.Error())
+		os.Exit(ExitCode)
+	}
+}
+
+func run(encoded string) error {
+	var spec Spec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		return fmt.Errorf("invalid process spec: %s", err.Error())
+	}
+	if len(os.Args) < 2 {
+		return fmt.Errorf("no command to run")
+	}
+	if spec.Cgroup != "" {
+		// the processes the command starts are in the cgroup too
+		procs := filepath.Join(spec.Cgroup, "cgroup.procs")
+		if err := ioutil.WriteFile(procs, []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+			return fmt.Errorf("unable to join cgroup: %s", err.Error())
+		}
+	}
+	for _, rlimit := range spec.Rlimits {
+		if err := setRlimit(rlimit); err != nil {
+			return err
+		}
+	}
+	if spec.SandboxWorkDir != "" {
+		if err := setupSandbox(spec.SandboxWorkDir); err != nil {
+			return fmt.Errorf("unable to set up sandbox: %s", err.Error())
+		}
+	}
+	if spec.Credential != nil {
+		if err := dropPrivileges(*spec.Credential); err != nil {
+			return err
+		}
+	}
+	if spec.ParentDeathSignal != 0 {
+		if err := setParentDeathSignal(spec.ParentDeathSignal, spec.Parent); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Exec(os.Args[0], os.Args[1:], os.Environ()); err != nil {
+		return fmt.Errorf("unable to run %s: %s", os.Args[0], err.Error())
+	}
+	return nil
+}
+
+// dropPrivileges switches to credential, the supplementary groups first because only root can
+// change them.
+func dropPrivileges(credential Credential) error {
+	groups := make([]int, len(credential.Groups))
+	for i, group := range credential.Groups {
+		groups[i] = int(group)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("unable to set supplementary groups: %s", err.Error())
+	}
+	if err := syscall.Setgid(int(credential.GID)); err != nil {
+		return fmt.Errorf("unable to set group to %d: %s", credential.GID, err.Error())
+	}
+	if err := syscall.Setuid(int(credential.UID)); err != nil {
+		return fmt.Errorf("unable to set user to %d: %s", credential.UID, err.Error())
+	}
+	return nil
+}
+
+func setRlimit(rlimit Rlimit) error {
+	resource, ok := rlimitResources[rlimit.Name]
+	if !ok {
+		return fmt.Errorf("unknown resource limit %q", rlimit.Name)
+	}
+	var current syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &current); err != nil {
+		return fmt.Errorf("unable to get the %s limit: %s", rlimit.Name, err.Error())
+	}
+	limit := syscall.Rlimit{Cur: rlimit.Value, Max: rlimit.Value}
+	if rlimit.Name == "cpu" {
+		// SIGXCPU is only sent at the soft limit, at the hard limit the process is killed
+		limit.Max++
+	}
+	// without privileges the hard limit can't be raised
+	if limit.Max > current.Max {
+		limit.Max = current.Max
+	}
+	if limit.Cur > limit.Max {
+		limit.Cur = limit.Max
+	}
+	if err := syscall.Setrlimit(resource, &limit); err != nil {
+		return fmt.Errorf("unable to set the %s limit to %d: %s", rlimit.Name, rlimit.Value, err.Error())
+	}
+	return nil
+}
+
+// CheckSandbox returns why processes can't be sandboxed, e.g. because the kernel doesn't
+// support the namespaces or gearcmd lacks the privileges to create them, by running /bin/true
+// in a sandbox with workDir as its writable directory.
+func CheckSandbox(workDir string) error {
+	cmd := exec.Command("/bin/true")
+	if err := Wrap(cmd, Spec{SandboxWorkDir: workDir}); err != nil {
+		return err
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s: %s", err.Error(), message)
+		}
+		return err
+	}
+	return nil
+}
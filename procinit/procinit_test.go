@@ -0,0 +1,72 @@
+package procinit
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+func TestParseRlimits(t *testing.T) {
+	rlimits, err := ParseRlimits("as=1073741824, cpu=60,nofile=1024,nproc=100,core=0")
+	assert.NoError(t, err)
+	assert.Equal(t, []Rlimit{{"as", 1073741824}, {"cpu", 60}, {"nofile", 1024}, {"nproc", 100}, {"core", 0}}, rlimits)
+
+	rlimits, err = ParseRlimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, rlimits)
+}
+
+func TestParseRlimitsInvalid(t *testing.T) {
+	for _, limits := range []string{"cpu", "memory=10", "cpu=-1", "cpu=ten"} {
+		_, err := ParseRlimits(limits)
+		assert.Error(t, err, limits)
+	}
+}
+
+func TestWrapSetsRlimits(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", `ulimit -n; ulimit -c; echo "$0 $1"`, "arg0", "arg1")
+	assert.NoError(t, Wrap(cmd, Spec{Rlimits: []Rlimit{{"nofile", 64}, {"core", 0}}}))
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err)
+	assert.Equal(t, "64\n0\narg0 arg1\n", string(output))
+}
+
+func TestWrapWithoutSpec(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	assert.NoError(t, Wrap(cmd, Spec{}))
+	assert.Equal(t, "/bin/true", cmd.Path)
+	assert.Equal(t, []string{"/bin/true"}, cmd.Args)
+	assert.Nil(t, cmd.Env)
+}
+
+func TestSetupFailure(t *testing.T) {
+	cmd := exec.Command("/nonexistent")
+	// exec.Command only fails at Start if the path can't be found, so set it directly
+	cmd.Path = "/nonexistent"
+	assert.NoError(t, Wrap(cmd, Spec{Rlimits: []Rlimit{{"nofile", 64}}}))
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); assert.True(t, ok) {
+		assert.False(t, exitErr.Success())
+	}
+	assert.Contains(t, string(output), "gearcmd: unable to run /nonexistent")
+}
+
+func TestWrapMovesCredential(t *testing.T) {
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: &syscall.Credential{Uid: 1, Gid: 2}}
+	attr := cmd.SysProcAttr
+	assert.NoError(t, Wrap(cmd, Spec{Rlimits: []Rlimit{{"nofile", 64}}}))
+	assert.Nil(t, cmd.SysProcAttr.Credential)
+	assert.True(t, cmd.SysProcAttr.Setpgid)
+	// the caller's SysProcAttr isn't changed
+	assert.NotNil(t, attr.Credential)
+	assert.Contains(t, cmd.Env[len(cmd.Env)-1], `"credential":{"uid":1,"gid":2,"groups":null}`)
+}
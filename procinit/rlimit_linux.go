@@ -0,0 +1,15 @@
+package procinit
+
+import "syscall"
+
+// rlimitNproc isn't in the syscall package.
+const rlimitNproc = 6
+
+var rlimitResources = map[string]int{
+	"as":     syscall.RLIMIT_AS,
+	"core":   syscall.RLIMIT_CORE,
+	"cpu":    syscall.RLIMIT_CPU,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"nofile": syscall.RLIMIT_NOFILE,
+	"nproc":  rlimitNproc,
+}
@@ -0,0 +1,125 @@
+package procinit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// prSetNoNewPrivs isn't in the syscall package.
+const prSetNoNewPrivs = 38
+
+// sandboxProcAttr starts the process in namespaces of its own: mounts, process IDs, IPC and
+// network, which has nothing but a loopback interface that's down.
+func sandboxProcAttr(attr *syscall.SysProcAttr) {
+	attr.Cloneflags |= syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC
+}
+
+// setupSandbox makes every mount read-only except workDir, gives the process a /proc of its
+// own PID namespace and stops it from gaining privileges. It must run in the namespaces of
+// sandboxProcAttr.
+func setupSandbox(workDir string) error {
+	// keep the changes to this mount namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("unable to make mounts private: %s", err.Error())
+	}
+	if err := syscall.Unmount("/proc", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unable to unmount /proc: %s", err.Error())
+	}
+	if err := syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("unable to mount /proc: %s", err.Error())
+	}
+	// a bind mount is a mount of its own, so it stays writable
+	workDir, err := filepath.EvalSymlinks(workDir)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Mount(workDir, workDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("unable to bind mount %s: %s", workDir, err.Error())
+	}
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if mount.path == workDir {
+			continue
+		}
+		flags := uintptr(syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY) | mount.flags
+		if err := syscall.Mount("", mount.path, "", flags, ""); err != nil {
+			if err == syscall.ENOENT {
+				// hidden by a mount over one of its parents
+				continue
+			}
+			return fmt.Errorf("unable to make %s read-only: %s", mount.path, err.Error())
+		}
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("unable to set no_new_privs: %s", errno.Error())
+	}
+	return nil
+}
+
+type mountPoint struct {
+	path string
+	// flags are the mount's flags that have to be kept when it's remounted
+	flags uintptr
+}
+
+// mountPoints returns the process' mount points.
+func mountPoints() ([]mountPoint, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var mounts []mountPoint
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+		mount := mountPoint{path: unescapeMountPath(fields[4])}
+		for _, option := range strings.Split(fields[5], ",") {
+			switch option {
+			case "nosuid":
+				mount.flags |= syscall.MS_NOSUID
+			case "nodev":
+				mount.flags |= syscall.MS_NODEV
+			case "noexec":
+				mount.flags |= syscall.MS_NOEXEC
+			case "noatime":
+				mount.flags |= syscall.MS_NOATIME
+			case "nodiratime":
+				mount.flags |= syscall.MS_NODIRATIME
+			case "relatime":
+				mount.flags |= syscall.MS_RELATIME
+			}
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath undoes the octal escapes of spaces, tabs, newlines and backslashes in
+// mountinfo paths.
+func unescapeMountPath(path string) string {
+	var unescaped []byte
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				unescaped = append(unescaped, byte(c))
+				i += 3
+				continue
+			}
+		}
+		unescaped = append(unescaped, path[i])
+	}
+	return string(unescaped)
+}
@@ -0,0 +1,36 @@
+package procinit
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnescapeMountPath(t *testing.T) {
+	assert.Equal(t, "/mnt/a dir\tb\\c", unescapeMountPath(`/mnt/a\040dir\011b\134c`))
+	assert.Equal(t, `/mnt/\04`, unescapeMountPath(`/mnt/\04`))
+	assert.Equal(t, "/", unescapeMountPath("/"))
+}
+
+func TestSandbox(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "procinit-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(workDir)
+	if err := CheckSandbox(workDir); err != nil {
+		t.Skip(err.Error())
+	}
+	outside := filepath.Join(filepath.Dir(workDir), filepath.Base(workDir)+"-outside")
+	defer os.Remove(outside)
+	cmd := exec.Command("/bin/sh", "-c", `echo $$; touch "$0/file" && echo ok; touch "$1" || echo read-only`,
+		workDir, outside)
+	assert.NoError(t, Wrap(cmd, Spec{SandboxWorkDir: workDir}))
+	output, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "1\nok\nread-only\n", string(output))
+	_, err = os.Stat(filepath.Join(workDir, "file"))
+	assert.NoError(t, err)
+}
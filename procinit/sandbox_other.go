@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package procinit
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func sandboxProcAttr(attr *syscall.SysProcAttr) {}
+
+func setupSandbox(workDir string) error {
+	return fmt.Errorf("sandboxing is only supported on Linux")
+}
@@ -0,0 +1,118 @@
+// Package watchdog kills the process groups of the jobs gearcmd runs if gearcmd dies, e.g.
+// because it's SIGKILLed or OOM killed, so a job gearmand hands to another worker doesn't keep
+// running here too.
+//
+// The watchdog is a copy of the gearcmd binary started with an environment variable. Init,
+// which the binary has to call before anything else in main, notices the variable and runs
+// the watchdog instead of gearcmd. The watchdog reads the process groups to watch from a pipe
+// only gearcmd holds the other end of, so it sees EOF once gearcmd is gone, however it died.
+package watchdog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"gopkg.in/Clever/kayvee-go.v6/logger"
+)
+
+// envVar is set for the watchdog process.
+const envVar = "GEARCMD_WATCHDOG"
+
+var lg = logger.New("gearcmd")
+
+// Watchdog is a running watchdog process.
+type Watchdog struct {
+	mu  sync.Mutex
+	cmd *exec.Cmd
+	w   *os.File
+}
+
+// Start starts a watchdog process. It keeps running until gearcmd exits.
+func Start() (*Watchdog, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the gearcmd executable: %s", err.Error())
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command(self)
+	cmd.Env = append(os.Environ(), envVar+"=1")
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// in a session of its own, signals sent to gearcmd's process group don't reach it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("unable to start watchdog: %s", err.Error())
+	}
+	go cmd.Wait()
+	return &Watchdog{cmd: cmd, w: w}, nil
+}
+
+// Pid returns the watchdog's process ID.
+func (w *Watchdog) Pid() int {
+	return w.cmd.Process.Pid
+}
+
+// Watch makes the watchdog kill process group pgid if gearcmd dies before Unwatch is called.
+func (w *Watchdog) Watch(pgid int) error {
+	return w.send('+', pgid)
+}
+
+// Unwatch stops watching process group pgid.
+func (w *Watchdog) Unwatch(pgid int) error {
+	return w.send('-', pgid)
+}
+
+func (w *Watchdog) send(op byte, pgid int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.w, "%c%d\n", op, pgid)
+	return err
+}
+
+// Init runs the watchdog if the process was started by Start, and returns right away
+// otherwise. It never returns in the first case.
+func Init() {
+	if _, ok := os.LookupEnv(envVar); !ok {
+		return
+	}
+	run(os.Stdin)
+	os.Exit(0)
+}
+
+// run watches the process groups read from r until EOF, then kills the ones still watched.
+func run(r io.Reader) {
+	pgids := map[int]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 2 {
+			continue
+		}
+		pgid, err := strconv.Atoi(line[1:])
+		if err != nil || pgid <= 0 {
+			continue
+		}
+		switch line[0] {
+		case '+':
+			pgids[pgid] = true
+		case '-':
+			delete(pgids, pgid)
+		}
+	}
+	for pgid := range pgids {
+		lg.WarnD("watchdog-kill", logger.M{"pgid": pgid, "message": "gearcmd exited while the job was running"})
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,63 @@
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	Init()
+	os.Exit(m.Run())
+}
+
+// startGroup starts a process in a process group of its own.
+func startGroup(t *testing.T) *exec.Cmd {
+	cmd := exec.Command("sleep", "100")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	assert.NoError(t, cmd.Start())
+	return cmd
+}
+
+// waitKilled returns whether cmd exits because it's killed within a few seconds.
+func waitKilled(cmd *exec.Cmd) bool {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		exitErr, ok := err.(*exec.ExitError)
+		return ok && exitErr.Sys().(syscall.WaitStatus).Signal() == syscall.SIGKILL
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		return false
+	}
+}
+
+func TestRunKillsWatchedGroups(t *testing.T) {
+	watched := startGroup(t)
+	unwatched := startGroup(t)
+	defer func() {
+		unwatched.Process.Kill()
+		unwatched.Wait()
+	}()
+	run(strings.NewReader(fmt.Sprintf("+%d\n+%d\n-%d\ninvalid\n", watched.Process.Pid, unwatched.Process.Pid,
+		unwatched.Process.Pid)))
+	assert.True(t, waitKilled(watched))
+	assert.NoError(t, syscall.Kill(unwatched.Process.Pid, 0))
+}
+
+func TestWatchdogKillsWhenGearcmdIsGone(t *testing.T) {
+	w, err := Start()
+	assert.NoError(t, err)
+	watched := startGroup(t)
+	assert.NoError(t, w.Watch(watched.Process.Pid))
+	// the same EOF the watchdog sees when gearcmd dies
+	w.w.Close()
+	assert.True(t, waitKilled(watched))
+}
@@ -0,0 +1,85 @@
+/*
+Package workdata encodes and decodes the WORK_DATA a gearcmd worker sends for a job.
+
+With -output-encoding=gzip the command's stdout is gzipped before it's split into WORK_DATA
+packets, so a client has to join all the packets of a job and decode them:
+
+	var data []byte
+	for packet := range packets {
+		data = append(data, packet...)
+	}
+	output, err := workdata.Decode(data)
+
+Decode leaves data that isn't gzipped as is, so clients can use it whatever the worker's
+encoding is.
+*/
+package workdata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// The supported encodings for WORK_DATA.
+const (
+	// EncodingIdentity sends stdout unchanged.
+	EncodingIdentity = "identity"
+	// EncodingGzip sends stdout as a single gzip stream.
+	EncodingGzip = "gzip"
+)
+
+// gzipMagic are the first bytes of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ValidateEncoding returns an error if encoding isn't one of the supported encodings.
+func ValidateEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingIdentity, EncodingGzip:
+		return nil
+	}
+	return fmt.Errorf("unknown output encoding %q, must be %s or %s", encoding, EncodingIdentity, EncodingGzip)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewWriter returns a writer that encodes what's written to it and writes it to w. It must be
+// closed to write the end of the encoded data, which doesn't close w.
+func NewWriter(w io.Writer, encoding string) (io.WriteCloser, error) {
+	if err := ValidateEncoding(encoding); err != nil {
+		return nil, err
+	}
+	if encoding == EncodingGzip {
+		return gzip.NewWriter(w), nil
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// NewReader returns a reader that decodes r if it's gzipped, and otherwise reads r as is.
+func NewReader(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, nil
+	}
+	return gzip.NewReader(buffered)
+}
+
+// Decode returns the decoded WORK_DATA of a job, given all of its packets joined together.
+func Decode(data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
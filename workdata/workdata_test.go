@@ -0,0 +1,44 @@
+package workdata
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encode(t *testing.T, encoding string, data string) []byte {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, encoding)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	csv := bytes.Repeat([]byte("id,name,grade\n1,Ada,5\n"), 1000)
+	encoded := encode(t, EncodingGzip, string(csv))
+	assert.True(t, len(encoded) < len(csv))
+	decoded, err := Decode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, csv, decoded)
+}
+
+func TestDecodeLeavesPlainDataAsIs(t *testing.T) {
+	for _, data := range []string{"", "x", "plain output\n"} {
+		encoded := encode(t, EncodingIdentity, data)
+		decoded, err := Decode(encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(decoded))
+	}
+}
+
+func TestValidateEncoding(t *testing.T) {
+	assert.NoError(t, ValidateEncoding(""))
+	assert.NoError(t, ValidateEncoding(EncodingGzip))
+	assert.Error(t, ValidateEncoding("brotli"))
+	_, err := NewWriter(&bytes.Buffer{}, "brotli")
+	assert.Error(t, err)
+}